@@ -96,6 +96,7 @@ func (n QuantifierNode) getAllMatchesWithCaptures(input []byte, pos int) []Quant
 			break
 		}
 
+		empty := matchResult.EndPos == curr
 		curr = matchResult.EndPos
 		matchCount++
 		currentCaptures = mergeCaptures(currentCaptures, matchResult.Captures)
@@ -104,6 +105,11 @@ func (n QuantifierNode) getAllMatchesWithCaptures(input []byte, pos int) []Quant
 			EndPos:   curr,
 			Captures: currentCaptures,
 		})
+
+		// Like Perl, an iteration that matches the empty string ends the loop, it would repeat forever
+		if empty {
+			break
+		}
 	}
 
 	// Order results based on greediness
@@ -282,6 +288,46 @@ func (n CaptureNode) match(input []byte, pos int) MatchResult {
 	}
 }
 
+// The child is matched as a one-element sequence, so a quantifier child is handled like anywhere else.
+// Only its first (highest priority) match is kept, SequenceNode never asks an AtomicNode for another one.
+func (n AtomicNode) match(input []byte, pos int) MatchResult {
+	return SequenceNode{Children: []Node{n.Child}}.match(input, pos)
+}
+
+// A lookahead matches its child from pos, a lookbehind from every start before pos
+// (nearest first) with the child pinned to end at pos. Nothing is consumed either way,
+// captures are only kept from a positive lookaround.
+//
+// The lookbehind needs every way its child can match, not just the first one:
+// in (?<=a|ab)c on "abc" the a alternative ends before b, only ab ends at c.
+// match never backtracks into an alternation, so the tagged engine's matchAll lists them.
+func (n LookaroundNode) match(input []byte, pos int) MatchResult {
+	result := MatchResult{Success: false, EndPos: pos, Captures: []string{}}
+
+	if n.Behind {
+		pinned := SequenceNode{Children: []Node{n.Child, positionNode{Pos: pos}}}
+		captures := make([]string, n.NumGroups)
+		for start := pos; start >= 0 && !result.Success; start-- {
+			if matches := pinned.matchAll(input, start, captures); len(matches) > 0 {
+				result = MatchResult{Success: true, EndPos: pos, Captures: matches[0].Captures}
+			}
+		}
+	} else {
+		result = SequenceNode{Children: []Node{n.Child}}.match(input, pos)
+	}
+
+	if n.Negated {
+		return MatchResult{Success: !result.Success, EndPos: pos, Captures: []string{}}
+	}
+
+	result.EndPos = pos
+	return result
+}
+
+func (n positionNode) match(input []byte, pos int) MatchResult {
+	return MatchResult{Success: pos == n.Pos, EndPos: pos, Captures: []string{}}
+}
+
 func (n AlternationNode) match(input []byte, pos int) MatchResult {
 	for _, child := range n.Children {
 		result := child.match(input, pos)
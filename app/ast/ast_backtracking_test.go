@@ -0,0 +1,112 @@
+package main
+
+import "testing"
+
+func TestLookbehind(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    bool
+	}{
+		{`(?<=a|ab)c`, "abc", true},
+		{`(?<=ab|a)c`, "abc", true},
+		{`(?<=x(a|ab))c`, "xabc", true},
+		{`(?<=a|ab)c`, "bc", false},
+		{`(?<!a|ab)c`, "abc", false},
+		{`(?<!a|ab)c`, "xbc", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.input, func(t *testing.T) {
+			ast, _, err := parse(tt.pattern)
+			if err != nil {
+				t.Fatalf("parse(%q) error: %v", tt.pattern, err)
+			}
+
+			got := false
+			for pos := 0; pos <= len(tt.input) && !got; pos++ {
+				got = ast.match([]byte(tt.input), pos).Success
+			}
+			if got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// An iteration that matches the empty string ends the loop, (a*)* used to repeat it forever
+func TestEmptyIterations(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    int // end of the first match at position 0, -1 for none
+	}{
+		{`x(a*)*`, "xb", 1},
+		{`(a*)*b`, "aab", 3},
+		{`(a?)*c`, "aac", 3},
+		{`(a*)+x`, "aax", 3},
+		{`(a*|b)*?c`, "abc", 3},
+		{`(a*)*x`, "aab", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.input, func(t *testing.T) {
+			ast, numGroups, err := parse(tt.pattern)
+			if err != nil {
+				t.Fatalf("parse(%q) error: %v", tt.pattern, err)
+			}
+
+			got := -1
+			if results := ast.matchAll([]byte(tt.input), 0, make([]string, numGroups)); len(results) > 0 {
+				got = results[0].EndPos
+			}
+			if got != tt.want {
+				t.Errorf("matchAll(%q) ends at %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	// match has no nested quantifiers, a lookahead is enough to loop
+	ast, _, err := parse(`x(?=a)*a`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if result := ast.match([]byte("xa"), 0); !result.Success || result.EndPos != 2 {
+		t.Errorf("match(%q) = %+v, want the match \"xa\"", "xa", result)
+	}
+}
+
+// An atomic group keeps the first way its child matches, the rest of the pattern can't backtrack into it
+func TestAtomicGroups(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    int // end of the first match at position 0, -1 for none
+	}{
+		{`(?>a+)b`, "aaab", 4},
+		{`(?>a+)ab`, "aaab", -1},
+		{`a+ab`, "aaab", 4},
+		{`(?>a*)a`, "aaa", -1},
+		{`(?>a|ab)c`, "abc", -1},
+		{`(?>ab|a)c`, "abc", 3},
+		{`(?>(a+))b`, "aab", 3},
+		{`x(?>a*?)a`, "xa", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.input, func(t *testing.T) {
+			ast, numGroups, err := parse(tt.pattern)
+			if err != nil {
+				t.Fatalf("parse(%q) error: %v", tt.pattern, err)
+			}
+
+			got := -1
+			if results := ast.matchAll([]byte(tt.input), 0, make([]string, numGroups)); len(results) > 0 {
+				got = results[0].EndPos
+			}
+			if got != tt.want {
+				t.Errorf("matchAll(%q) ends at %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -51,6 +51,32 @@ type DotNode struct{}
 type CaptureNode struct {
 	Child    Node
 	GroupIdx int
+	Name     string // "" unless the group is (?P<name>...) or (?<name>...)
+}
+
+// AtomicNode is (?>...): once the child has matched, the rest of the pattern
+// can't backtrack into it to try its other matches
+//
+//	(?>a+)ab on "aaab": a+ keeps all three a's, nothing is left for "ab", no match
+type AtomicNode struct {
+	Child Node
+}
+
+// LookaroundNode is a zero-width assertion on the text around the position:
+//
+//	(?=re)   re matches starting here        (?!re)   it doesn't
+//	(?<=re)  re matches ending here          (?<!re)  it doesn't
+type LookaroundNode struct {
+	Child     Node
+	Behind    bool
+	Negated   bool
+	NumGroups int // groups numbered by the end of the child, the captures a lookbehind's child fills
+}
+
+// positionNode only matches at Pos, a lookbehind appends it to its child
+// so the child has to end exactly where the lookbehind is
+type positionNode struct {
+	Pos int
 }
 
 type AlternationNode struct {
@@ -258,10 +284,49 @@ func (p *Parser) parseCharClass() (Node, error) {
 	return CharClassNode{Chars: chars, Negated: negated}, nil
 }
 
+// parseGroup parses a group after its '(':
+//
+//	(re)  (?P<name>re)  (?<name>re)   capturing, named groups are numbered too
+//	(?:re)                            non-capturing
+//	(?>re)                            atomic
+//	(?=re)  (?!re)  (?<=re)  (?<!re)  lookarounds
 func (p *Parser) parseGroup() (Node, error) {
+	kind := byte('(')
+	name := ""
+	behind := false
+
+	if p.peek() == '?' {
+		p.advance() // consume '?'
+
+		kind = p.advance()
+		if kind == 'P' && p.peek() == '<' {
+			kind = p.advance()
+		}
+		if kind == '<' && (p.peek() == '=' || p.peek() == '!') {
+			kind = p.advance()
+			behind = true
+		}
+
+		switch kind {
+		case ':', '>', '=', '!':
+		case '<':
+			end := strings.IndexByte(p.pattern[p.pos:], '>')
+			if end <= 0 {
+				return nil, fmt.Errorf("invalid group name at position %d", p.pos)
+			}
+			name = p.pattern[p.pos : p.pos+end]
+			p.pos += end + 1
+
+		default:
+			return nil, fmt.Errorf("unknown group type (?%c at position %d", kind, p.pos-1)
+		}
+	}
+
 	// Assign group number and increment
 	groupIdx := p.nextGroupId
-	p.nextGroupId++
+	if kind == '(' || kind == '<' {
+		p.nextGroupId++
+	}
 
 	// Parse the content inside parentheses
 	content, err := p.parseExpression()
@@ -275,7 +340,16 @@ func (p *Parser) parseGroup() (Node, error) {
 	}
 	p.advance() // consume ')'
 
-	return CaptureNode{Child: content, GroupIdx: groupIdx}, nil
+	switch kind {
+	case ':':
+		return content, nil
+	case '>':
+		return AtomicNode{Child: content}, nil
+	case '=', '!':
+		return LookaroundNode{Child: content, Behind: behind, Negated: kind == '!', NumGroups: p.nextGroupId}, nil
+	default:
+		return CaptureNode{Child: content, GroupIdx: groupIdx, Name: name}, nil
+	}
 }
 
 func printAST(node Node) string {
@@ -351,7 +425,23 @@ func prettyPrint(node Node, prefix string, isLast bool) string {
 		}
 
 	case CaptureNode:
-		result.WriteString(fmt.Sprintf("%s%sCapture(group_%d)\n", prefix, connector, node.GroupIdx))
+		label := fmt.Sprintf("group_%d", node.GroupIdx)
+		if node.Name != "" {
+			label += ", " + node.Name
+		}
+		result.WriteString(fmt.Sprintf("%s%sCapture(%s)\n", prefix, connector, label))
+
+		childPrefix := prefix
+		if isLast {
+			childPrefix += "   "
+		} else {
+			childPrefix += "│  "
+		}
+
+		result.WriteString(prettyPrint(node.Child, childPrefix, true))
+
+	case AtomicNode:
+		result.WriteString(fmt.Sprintf("%s%sAtomic\n", prefix, connector))
 
 		childPrefix := prefix
 		if isLast {
@@ -361,6 +451,26 @@ func prettyPrint(node Node, prefix string, isLast bool) string {
 		}
 
 		result.WriteString(prettyPrint(node.Child, childPrefix, true))
+
+	case LookaroundNode:
+		label := "Lookahead"
+		if node.Behind {
+			label = "Lookbehind"
+		}
+		if node.Negated {
+			label = "Negative" + label
+		}
+		result.WriteString(fmt.Sprintf("%s%s%s\n", prefix, connector, label))
+
+		childPrefix := prefix
+		if isLast {
+			childPrefix += "   "
+		} else {
+			childPrefix += "│  "
+		}
+
+		result.WriteString(prettyPrint(node.Child, childPrefix, true))
+
 	case AlternationNode:
 		result.WriteString(fmt.Sprintf("%s%sAlternation\n", prefix, connector))
 
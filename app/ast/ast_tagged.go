@@ -84,6 +84,48 @@ func (n CaptureNode) matchAll(input []byte, pos int, captures []string) []MatchR
 	return childMatches
 }
 
+// Results are in priority order, an atomic group only offers the first one
+func (n AtomicNode) matchAll(input []byte, pos int, captures []string) []MatchResult {
+	childMatches := n.Child.matchAll(input, pos, captures)
+	if len(childMatches) == 0 {
+		return nil
+	}
+
+	return childMatches[:1]
+}
+
+// Same as the backtracking version, the first match of the child is enough to decide
+func (n LookaroundNode) matchAll(input []byte, pos int, captures []string) []MatchResult {
+	var childMatches []MatchResult
+	if n.Behind {
+		for start := pos; start >= 0 && len(childMatches) == 0; start-- {
+			for _, match := range n.Child.matchAll(input, start, captures) {
+				if match.EndPos == pos {
+					childMatches = append(childMatches, match)
+				}
+			}
+		}
+	} else {
+		childMatches = n.Child.matchAll(input, pos, captures)
+	}
+
+	switch {
+	case n.Negated && len(childMatches) == 0:
+		return []MatchResult{{EndPos: pos, Captures: slices.Clone(captures)}}
+	case n.Negated || len(childMatches) == 0:
+		return nil
+	default:
+		return []MatchResult{{EndPos: pos, Captures: childMatches[0].Captures}}
+	}
+}
+
+func (n positionNode) matchAll(input []byte, pos int, captures []string) []MatchResult {
+	if pos == n.Pos {
+		return []MatchResult{{EndPos: pos, Captures: slices.Clone(captures)}}
+	}
+	return nil
+}
+
 func (n AlternationNode) matchAll(input []byte, pos int, captures []string) []MatchResult {
 	var allResults []MatchResult
 	for _, child := range n.Children {
@@ -94,15 +136,24 @@ func (n AlternationNode) matchAll(input []byte, pos int, captures []string) []Ma
 }
 
 func (n QuantifierNode) matchAll(input []byte, pos int, captures []string) []MatchResult {
-	var allResults []MatchResult
-
-	// Try each possible match count from Min to Max
-	for matchCount := n.Min; n.Max == -1 || matchCount <= n.Max; matchCount++ {
-		exactResults := n.matchExactly(input, pos, captures, matchCount)
-		if len(exactResults) == 0 {
-			break // Can't match this many, so we can't match any more
+	// Min matches first, then one more iteration at a time up to Max.
+	// Like Perl, an iteration past Min that matches the empty string ends the loop:
+	// it is a result, but (a*)* would repeat it forever if it went on.
+	level := n.matchExactly(input, pos, captures, n.Min)
+	allResults := level
+
+	for matchCount := n.Min; len(level) > 0 && (n.Max == -1 || matchCount < n.Max); matchCount++ {
+		var next, grown []MatchResult
+		for _, result := range level {
+			for _, match := range n.Child.matchAll(input, result.EndPos, result.Captures) {
+				next = append(next, match)
+				if match.EndPos != result.EndPos {
+					grown = append(grown, match)
+				}
+			}
 		}
-		allResults = append(allResults, exactResults...)
+		allResults = append(allResults, next...)
+		level = grown
 	}
 
 	if len(allResults) == 0 {
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Colors (--color)
+//
+// Highlighting uses ANSI SGR sequences: "\033[01;31m" starts bold red, "\033[m" goes back to normal.
+// Each one is followed by "\033[K" (erase to the end of the line), which makes a background color
+// fill the rest of the line when the terminal scrolls.
+//
+// Like GNU grep, the colors come from GREP_COLORS, a colon-separated list of capabilities
+// that override the defaults ms=01;31:mc=01;31:sl=:cx=:fn=35:ln=32:bn=32:se=36
+//
+//	ms  matching text in selected lines        mc  matching text in context lines (-v)
+//	mt  sets both ms and mc
+//	sl  the rest of selected lines             cx  the rest of context lines
+//	fn  file names    ln  line numbers and columns    bn  byte offsets    se  separators (: - --)
+//	rv  with -v, sl is for context lines and cx for selected lines
+//	ne  no "\033[K"
+//	g1 ... g9  capture groups inside a match, not in GNU grep: groups have no color of their own by default.
+//	           -P only, -E and -G matches don't report their groups (see nfa.Longest)
+//
+// An empty value means no color. Unknown capabilities are skipped, a malformed value ends the list.
+
+// colors are the SGR parameters of each part of the output, the zero colors print no color at all
+type colors struct {
+	selectedMatch string     // ms
+	contextMatch  string     // mc
+	selectedLine  string     // sl
+	contextLine   string     // cx
+	fileName      string     // fn
+	lineNumber    string     // ln
+	byteOffset    string     // bn
+	separator     string     // se
+	groups        [10]string // g1 to g9, groups[0] is unused
+	reverse       bool       // rv
+	noErase       bool       // ne
+}
+
+// loadColors returns the default colors overridden by the environment
+func loadColors() *colors {
+	c := &colors{
+		selectedMatch: "01;31",
+		contextMatch:  "01;31",
+		fileName:      "35",
+		lineNumber:    "32",
+		byteOffset:    "32",
+		separator:     "36",
+	}
+
+	// The single color of older greps, GREP_COLORS wins over it
+	if color := os.Getenv("GREP_COLOR"); color != "" {
+		fmt.Fprintf(os.Stderr, "%s: warning: GREP_COLOR='%s' is deprecated; use GREP_COLORS='mt=%s'\n", progName, color, color)
+		c.selectedMatch, c.contextMatch = color, color
+	}
+	c.parse(os.Getenv("GREP_COLORS"))
+
+	return c
+}
+
+// parse applies the capabilities of a GREP_COLORS value
+func (c *colors) parse(spec string) {
+	if spec == "" {
+		return
+	}
+
+	for _, capability := range strings.Split(spec, ":") {
+		name, value, hasValue := strings.Cut(capability, "=")
+		if hasValue && strings.Trim(value, "0123456789;") != "" {
+			return
+		}
+
+		switch name {
+		case "mt":
+			c.selectedMatch, c.contextMatch = value, value
+		case "ms":
+			c.selectedMatch = value
+		case "mc":
+			c.contextMatch = value
+		case "sl":
+			c.selectedLine = value
+		case "cx":
+			c.contextLine = value
+		case "fn":
+			c.fileName = value
+		case "ln":
+			c.lineNumber = value
+		case "bn":
+			c.byteOffset = value
+		case "se":
+			c.separator = value
+		case "rv":
+			c.reverse = true
+		case "ne":
+			c.noErase = true
+		default:
+			if len(name) == 2 && name[0] == 'g' && name[1] >= '1' && name[1] <= '9' {
+				c.groups[name[1]-'0'] = value
+			}
+		}
+	}
+}
+
+// start begins printing in color sgr, nothing when it's empty
+func (c *colors) start(sgr string) string {
+	if sgr == "" {
+		return ""
+	}
+	if c.noErase {
+		return "\033[" + sgr + "m"
+	}
+
+	return "\033[" + sgr + "m\033[K"
+}
+
+// end goes back to the normal color after start(sgr)
+func (c *colors) end(sgr string) string {
+	if sgr == "" {
+		return ""
+	}
+	if c.noErase {
+		return "\033[m"
+	}
+
+	return "\033[m\033[K"
+}
+
+// paint returns text in color sgr
+func (c *colors) paint(sgr string, text string) string {
+	return c.start(sgr) + text + c.end(sgr)
+}
+
+// hasGroups reports whether some capture group has a color, then matches need the slots of their groups
+func (c *colors) hasGroups() bool {
+	for _, sgr := range c.groups {
+		if sgr != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// lineColors returns the colors of a printed line and of its matches, sep is ':' for a selected line
+// and '-' for a context line
+func (c *colors) lineColors(sep byte, invert bool) (line string, match string) {
+	line, match = c.contextLine, c.contextMatch
+	if (sep == ':') != (invert && c.reverse) {
+		line = c.selectedLine
+	}
+	if sep == ':' {
+		match = c.selectedMatch
+	}
+
+	return line, match
+}
+
+// writeMatch writes the match text[loc[0]:loc[1]] in color, and the groups of loc
+// (slots like FindAllSubmatchIndex returns) that have a color in theirs.
+// A group inside another one comes after it, so its color wins.
+func (c *colors) writeMatch(b *strings.Builder, text []byte, loc []int, color string) {
+	if !c.hasGroups() || len(loc) <= 2 {
+		b.WriteString(c.start(color))
+		b.Write(text[loc[0]:loc[1]])
+		b.WriteString(c.end(color))
+		return
+	}
+
+	painted := make([]string, loc[1]-loc[0])
+	for i := range painted {
+		painted[i] = color
+	}
+	for g := 1; 2*g+1 < len(loc) && g < len(c.groups); g++ {
+		if c.groups[g] == "" || loc[2*g] < 0 {
+			continue
+		}
+		for i := max(loc[2*g], loc[0]); i < min(loc[2*g+1], loc[1]); i++ {
+			painted[i-loc[0]] = c.groups[g]
+		}
+	}
+
+	for i := 0; i < len(painted); {
+		j := i + 1
+		for j < len(painted) && painted[j] == painted[i] {
+			j++
+		}
+		b.WriteString(c.start(painted[i]))
+		b.Write(text[loc[0]+i : loc[0]+j])
+		b.WriteString(c.end(painted[i]))
+		i = j
+	}
+}
+
+// isColorTerminal reports whether stdout is a terminal that understands colors, for --color=auto
+func isColorTerminal() bool {
+	if term := os.Getenv("TERM"); term == "" || term == "dumb" {
+		return false
+	}
+
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
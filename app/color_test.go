@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadColors(t *testing.T) {
+	defaults := colors{
+		selectedMatch: "01;31",
+		contextMatch:  "01;31",
+		fileName:      "35",
+		lineNumber:    "32",
+		byteOffset:    "32",
+		separator:     "36",
+	}
+	with := func(change func(c *colors)) colors {
+		c := defaults
+		change(&c)
+		return c
+	}
+
+	tests := []struct {
+		grepColors string
+		want       colors
+	}{
+		{"", defaults},
+		{"mt=01;32", with(func(c *colors) { c.selectedMatch, c.contextMatch = "01;32", "01;32" })},
+		{"ms=34", with(func(c *colors) { c.selectedMatch = "34" })},
+		{"mc=34", with(func(c *colors) { c.contextMatch = "34" })},
+		{"mt=33:ms=34", with(func(c *colors) { c.selectedMatch, c.contextMatch = "34", "33" })},
+		{"sl=1:cx=2", with(func(c *colors) { c.selectedLine, c.contextLine = "1", "2" })},
+		{"fn=1:ln=2:bn=3:se=4", with(func(c *colors) { c.fileName, c.lineNumber, c.byteOffset, c.separator = "1", "2", "3", "4" })},
+		{"rv:ne", with(func(c *colors) { c.reverse, c.noErase = true, true })},
+		{"g1=32:g9=34", with(func(c *colors) { c.groups[1], c.groups[9] = "32", "34" })},
+
+		// An empty value means no color
+		{"ms=:fn=", with(func(c *colors) { c.selectedMatch, c.fileName = "", "" })},
+
+		// Unknown capabilities are skipped
+		{"xx=1:g0=1:g10=1:ln=1", with(func(c *colors) { c.lineNumber = "1" })},
+
+		// A malformed value ends the list, the capabilities before it stay
+		{"ln=1:fn=red:bn=1", with(func(c *colors) { c.lineNumber = "1" })},
+		{"ms=1m:ln=1", defaults},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.grepColors, func(t *testing.T) {
+			t.Setenv("GREP_COLOR", "")
+			t.Setenv("GREP_COLORS", tt.grepColors)
+
+			if got := loadColors(); *got != tt.want {
+				t.Errorf("loadColors() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMatch(t *testing.T) {
+	tests := []struct {
+		grepColors string
+		loc        []int
+		want       string
+	}{
+		{"", []int{1, 4}, "\033[01;31m\033[Kbcd\033[m\033[K"},
+		{"ne", []int{1, 4}, "\033[01;31mbcd\033[m"},
+		{"ne:ms=", []int{1, 4}, "bcd"},
+
+		// Groups keep the color of the match unless they have their own
+		{"ne", []int{1, 4, 2, 3}, "\033[01;31mbcd\033[m"},
+		{"ne:g1=32", []int{1, 4, 2, 3}, "\033[01;31mb\033[m\033[32mc\033[m\033[01;31md\033[m"},
+		{"ne:g1=32", []int{1, 4, -1, -1}, "\033[01;31mbcd\033[m"},
+
+		// The inner group comes later, its color wins
+		{"ne:g1=32:g2=34", []int{0, 4, 0, 4, 1, 2}, "\033[32ma\033[m\033[34mb\033[m\033[32mcd\033[m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.grepColors, func(t *testing.T) {
+			t.Setenv("GREP_COLOR", "")
+			t.Setenv("GREP_COLORS", tt.grepColors)
+			c := loadColors()
+
+			var b strings.Builder
+			c.writeMatch(&b, []byte("abcde"), tt.loc, c.selectedMatch)
+			if got := b.String(); got != tt.want {
+				t.Errorf("writeMatch(%v) = %q, want %q", tt.loc, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Context lines (-A, -B, -C)
+//
+// Selected lines are printed together with the lines around them. The printed lines form groups,
+// two groups that are not adjacent are separated by a "--" line:
+//
+//	input   grep -C1 a
+//	a       a         a selected line, "file:a" with a file name prefix
+//	b       b         a context line, "file-b"
+//	c       --
+//	d       d
+//	a       a
+//	e       e
+//
+// A line after a selected one is known to be context as soon as it's read and printed right away.
+// A line before one is only known to be context once the selected line is read,
+// so the last -B lines are kept in a ring buffer until then.
+//
+// Groups are separated across files too, the printer of each file asks config whether
+// anything was printed before it.
+//
+// Each printed line starts with the fields that were asked for, each followed by ':' on a selected line
+// and '-' on a context line:
+//
+//	file:12:5:340:text   file name, line number (-n), column of the first match (--column), byte offset (-b)
+//	file-13-352-text     context lines have no column
+//
+// With -o the column and byte offset are the ones of each match, --vimgrep prints the whole line once per match.
+// With --color every part gets its color from config, see color.go.
+
+// contextPrinter prints the selected lines of one input together with their context
+type contextPrinter struct {
+	cfg         *config
+	name        string   // the file name prefix
+	before      lineRing // lines not printed yet that can still be context before a selected line
+	afterLeft   int      // lines still to print after the last selected line
+	lineNo      int      // the current line, from 1
+	lastPrinted int      // the last line printed, 0 if none yet
+}
+
+func newContextPrinter(cfg *config, name string) *contextPrinter {
+	return &contextPrinter{
+		cfg:    cfg,
+		name:   name,
+		before: lineRing{size: max(cfg.beforeContext, 0)},
+	}
+}
+
+// next handles the next line of the input, offset is the position of its first byte in the input.
+// matches are the locations of the matches in a selected line: all of them for -o and --vimgrep,
+// the first one for --column.
+func (p *contextPrinter) next(line []byte, offset int, selected bool, matches [][]int) {
+	p.lineNo++
+
+	if !selected {
+		if p.afterLeft > 0 {
+			p.afterLeft--
+			p.printContext(bufferedLine{text: line, lineNo: p.lineNo, offset: offset})
+			p.lastPrinted = p.lineNo
+		} else {
+			p.before.push(line, p.lineNo, offset)
+		}
+		return
+	}
+
+	// The group starts with the buffered context, if it doesn't continue the last one
+	first := p.lineNo - p.before.n
+	if p.cfg.separateGroups && p.cfg.printedGroup && (p.lastPrinted == 0 || first > p.lastPrinted+1) {
+		fmt.Fprintln(p.cfg.out, p.cfg.colors.paint(p.cfg.colors.separator, p.cfg.groupSeparator))
+	}
+	p.cfg.printedGroup = true
+
+	p.before.drain(p.printContext)
+
+	switch {
+	case p.cfg.onlyMatching:
+		p.printMatches(':', p.lineNo, offset, line, matches)
+
+	case p.cfg.vimgrep:
+		// The line once per non-empty match, or once if there is none
+		printed := false
+		for _, loc := range matches {
+			if loc[0] < loc[1] {
+				p.printLine(p.prefix(':', p.lineNo, loc[0]+1, offset), ':', line, matches)
+				printed = true
+			}
+		}
+		if !printed {
+			p.printLine(p.prefix(':', p.lineNo, firstColumn(matches), offset), ':', line, matches)
+		}
+
+	default:
+		p.printLine(p.prefix(':', p.lineNo, firstColumn(matches), offset), ':', line, matches)
+	}
+
+	p.lastPrinted = p.lineNo
+	p.afterLeft = max(p.cfg.afterContext, 0)
+}
+
+// printContext prints a context line. -o prints their matches, which only -v context lines have,
+// the others print nothing but still join groups.
+func (p *contextPrinter) printContext(line bufferedLine) {
+	var matches [][]int
+	if p.cfg.invert && (p.cfg.onlyMatching || p.cfg.colors.contextMatch != "" || p.cfg.colors.hasGroups()) {
+		matches = p.cfg.findAll(line.text)
+	}
+
+	if p.cfg.onlyMatching {
+		p.printMatches('-', line.lineNo, line.offset, line.text, matches)
+		return
+	}
+	p.printLine(p.prefix('-', line.lineNo, 0, line.offset), '-', line.text, matches)
+}
+
+// printLine prints a whole line after its prefix. Like GNU grep, the matches are highlighted on the lines
+// that match: selected lines, or context lines with -v.
+func (p *contextPrinter) printLine(prefix string, sep byte, line []byte, matches [][]int) {
+	c := p.cfg.colors
+	lineColor, matchColor := c.lineColors(sep, p.cfg.invert)
+
+	var b strings.Builder
+	b.WriteString(prefix)
+
+	rest := 0 // the part of the line before it is written already
+	if matching := (sep == ':') != p.cfg.invert; matching && (matchColor != "" || c.hasGroups()) {
+		for _, loc := range matches {
+			if loc[0] == loc[1] {
+				continue
+			}
+			b.WriteString(c.start(lineColor))
+			b.Write(line[rest:loc[0]])
+			c.writeMatch(&b, line, loc, matchColor)
+			rest = loc[1]
+		}
+	}
+	if rest < len(line) {
+		b.WriteString(c.paint(lineColor, string(line[rest:])))
+	}
+	b.WriteByte('\n')
+
+	fmt.Fprint(p.cfg.out, b.String())
+}
+
+// printMatches prints each non-empty match on its own line for -o, empty matches select the line but print nothing
+func (p *contextPrinter) printMatches(sep byte, lineNo int, offset int, line []byte, matches [][]int) {
+	c := p.cfg.colors
+	_, matchColor := c.lineColors(sep, p.cfg.invert)
+
+	for _, loc := range matches {
+		if loc[0] == loc[1] {
+			continue
+		}
+
+		column := 0 // context lines have none
+		if sep == ':' {
+			column = loc[0] + 1
+		}
+
+		var b strings.Builder
+		b.WriteString(p.prefix(sep, lineNo, column, offset+loc[0]))
+		c.writeMatch(&b, line, loc, matchColor)
+		b.WriteByte('\n')
+		fmt.Fprint(p.cfg.out, b.String())
+	}
+}
+
+// prefix is what goes before a printed line, sep is ':' for selected lines and '-' for context lines.
+// column is 1-based, 0 leaves it out.
+func (p *contextPrinter) prefix(sep byte, lineNo int, column int, offset int) string {
+	c := p.cfg.colors
+	var b strings.Builder
+	field := func(color string, s string) {
+		b.WriteString(c.paint(color, s))
+		b.WriteString(c.paint(c.separator, string(sep)))
+	}
+
+	if p.cfg.withFileName {
+		field(c.fileName, p.name)
+	}
+	if p.cfg.lineNumber {
+		field(c.lineNumber, strconv.Itoa(lineNo))
+	}
+	if p.cfg.column && column > 0 {
+		field(c.lineNumber, strconv.Itoa(column))
+	}
+	if p.cfg.byteOffset {
+		field(c.byteOffset, strconv.Itoa(offset))
+	}
+
+	return b.String()
+}
+
+// firstColumn is the 1-based column of the first match, a line selected by -v has none and is reported from its start
+func firstColumn(matches [][]int) int {
+	if len(matches) == 0 {
+		return 1
+	}
+
+	return matches[0][0] + 1
+}
+
+// bufferedLine is a line with its position in the input
+type bufferedLine struct {
+	text   []byte
+	lineNo int
+	offset int
+}
+
+// lineRing keeps the last size lines pushed, the oldest one is overwritten when it's full.
+// It only grows as lines come, -B 1000000 doesn't allocate a million lines upfront.
+type lineRing struct {
+	size  int
+	lines []bufferedLine // the texts are copies, the scanner reuses its buffer
+	start int            // index of the oldest line, 0 until the ring is full
+	n     int            // number of lines kept
+}
+
+func (r *lineRing) push(text []byte, lineNo int, offset int) {
+	var i int
+	switch {
+	case r.n < len(r.lines):
+		i = (r.start + r.n) % len(r.lines)
+		r.n++
+
+	case len(r.lines) < r.size:
+		i = len(r.lines)
+		r.lines = append(r.lines, bufferedLine{})
+		r.n++
+
+	case r.size > 0:
+		i = r.start
+		r.start = (r.start + 1) % len(r.lines)
+
+	default:
+		return
+	}
+
+	r.lines[i] = bufferedLine{
+		text:   append(r.lines[i].text[:0], text...),
+		lineNo: lineNo,
+		offset: offset,
+	}
+}
+
+// drain calls visit on the lines from the oldest to the newest and empties the ring
+func (r *lineRing) drain(visit func(line bufferedLine)) {
+	for k := 0; k < r.n; k++ {
+		visit(r.lines[(r.start+k)%len(r.lines)])
+	}
+	r.start, r.n = 0, 0
+}
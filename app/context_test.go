@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// printContextLines runs a contextPrinter over lines, those containing "a" are selected
+func printContextLines(cfg *config, name string, lines []string) {
+	p := newContextPrinter(cfg, name)
+	offset := 0
+	for _, line := range lines {
+		p.next([]byte(line), offset, strings.Contains(line, "a"), nil)
+		offset += len(line) + 1
+	}
+}
+
+func TestContextGroupSeparators(t *testing.T) {
+	input := []string{"a", "b", "c", "d", "a", "e", "f", "g", "h", "a"}
+
+	tests := []struct {
+		name      string
+		after     int
+		before    int
+		separator string
+		separate  bool
+		want      string
+	}{
+		{"after", 1, 0, "--", true, "a\nb\n--\na\ne\n--\na\n"},
+		{"before", 0, 1, "--", true, "a\n--\nd\na\n--\nh\na\n"},
+		{"both", 1, 1, "--", true, "a\nb\n--\nd\na\ne\n--\nh\na\n"},
+		{"adjacent groups join", 2, 2, "--", true, "a\nb\nc\nd\na\ne\nf\ng\nh\na\n"},
+		{"custom separator", 1, 0, "==", true, "a\nb\n==\na\ne\n==\na\n"},
+		{"empty separator", 1, 0, "", true, "a\nb\n\na\ne\n\na\n"},
+		{"no separator", 1, 0, "--", false, "a\nb\na\ne\na\n"},
+		{"no context", 0, 0, "--", true, "a\n--\na\n--\na\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			cfg := &config{
+				out:            &out,
+				colors:         &colors{},
+				afterContext:   tt.after,
+				beforeContext:  tt.before,
+				groupSeparator: tt.separator,
+				separateGroups: tt.separate,
+			}
+
+			printContextLines(cfg, "", input)
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Groups are separated across files, but not before the first one
+func TestContextGroupSeparatorsAcrossFiles(t *testing.T) {
+	var out strings.Builder
+	cfg := &config{
+		out:            &out,
+		colors:         &colors{},
+		withFileName:   true,
+		lineNumber:     true,
+		afterContext:   1,
+		beforeContext:  1,
+		groupSeparator: "--",
+		separateGroups: true,
+	}
+
+	printContextLines(cfg, "x", []string{"b", "c"})
+	printContextLines(cfg, "y", []string{"b", "a", "c"})
+	printContextLines(cfg, "z", []string{"a"})
+
+	want := "y-1-b\ny:2:a\ny-3-c\n--\nz:1:a\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestLinePrefixes(t *testing.T) {
+	input := []string{"xa", "b", "yya"}
+
+	tests := []struct {
+		name string
+		cfg  config
+		want string
+	}{
+		{"line numbers", config{lineNumber: true, afterContext: 1}, "1:xa\n2-b\n3:yya\n"},
+		{"byte offsets", config{byteOffset: true}, "0:xa\n5:yya\n"},
+		{"column", config{lineNumber: true, column: true}, "1:2:xa\n3:3:yya\n"},
+		{"file name first", config{withFileName: true, lineNumber: true, byteOffset: true}, "f:1:0:xa\nf:3:5:yya\n"},
+		{"only matching offsets", config{onlyMatching: true, byteOffset: true, column: true}, "2:1:a\n3:7:a\n"},
+		{"vimgrep", config{withFileName: true, lineNumber: true, column: true, vimgrep: true}, "f:1:2:xa\nf:3:3:yya\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			cfg := tt.cfg
+			cfg.out = &out
+			cfg.colors = &colors{}
+
+			// Selected lines come with their matches, like matchReader finds them
+			p := newContextPrinter(&cfg, "f")
+			offset := 0
+			for _, line := range input {
+				var matches [][]int
+				if i := strings.IndexByte(line, 'a'); i >= 0 {
+					matches = [][]int{{i, i + 1}}
+				}
+				p.next([]byte(line), offset, matches != nil, matches)
+				offset += len(line) + 1
+			}
+
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/codecrafters-io/grep-starter-go/app/nfa"
 )
@@ -20,52 +23,230 @@ func isAlphaNumeric(char byte) bool {
 	return isSmall || isCapitalized || isDigit(char) || char == '_'
 }
 
+// Lines longer than this are reported as an error instead of being matched
+const maxLineSize = 1 << 30
+
+// config is everything that stays the same for all the files being searched, except printedGroup
+type config struct {
+	re           *nfa.Regexp
+	out          io.Writer // where the results go, os.Stdout
+	stdin        io.Reader // read for "-" and when there is no file operand, os.Stdin
+	invert       bool      // -v: select the lines that don't match
+	onlyMatching bool      // -o: print each match instead of the whole line
+	withFileName bool      // prefix output lines with the file name
+
+	// Also prefix them with, see context.go
+	lineNumber bool // -n
+	column     bool // --column: the 1-based column of the first match
+	byteOffset bool // -b: the offset of the line, or of the match with -o
+	vimgrep    bool // --vimgrep: all three, and the line once per match
+
+	colors *colors // --color, the zero colors without it
+
+	// Instead of the selected lines, print
+	count     bool // -c: how many there are
+	listFiles byte // -l: the file name if there is one, -L: if there is none
+	quiet     bool // -q: nothing, the exit status says whether there is one
+
+	// Context lines, see context.go
+	afterContext   int    // -A: lines printed after each selected line
+	beforeContext  int    // -B: lines printed before each selected line
+	groupSeparator string // printed between groups of lines that are not adjacent
+	separateGroups bool   // -A, -B or -C was given, without --no-group-separator
+	printedGroup   bool   // a group was printed, in this file or an earlier one
+
+	failed bool // a file or directory couldn't be read, the others are still searched
+}
+
 // Usage: echo <input_text> | your_program.sh -E <pattern>
 func main() {
-	if len(os.Args) < 3 || (os.Args[1] != "-E" && os.Args[1] != "-r") {
-		fmt.Fprintf(os.Stderr, "usage: mygrep -E <pattern>\n")
-		os.Exit(2) // 1 means no lines were selected, >1 means error
+	// Results are written in blocks rather than one write per line, flushed once run is done
+	out := bufio.NewWriter(os.Stdout)
+	status := run(os.Args[1:], os.Stdin, out)
+
+	if err := out.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: write output: %v\n", err)
+		status = 2
+	}
+	os.Exit(status)
+}
+
+// run is grep with the arguments args, it returns the exit status:
+// 0 when a line is selected, 1 when none is and 2 after an error
+func run(args []string, stdin io.Reader, out io.Writer) int {
+	opts, err := parseArgs(args)
+	if err != nil {
+		if msg := err.Error(); msg != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", progName, msg)
+		}
+		// A bad option gets the usage lines, a bad option argument like -A x only the message
+		var usage *usageError
+		if errors.As(err, &usage) {
+			fmt.Fprintf(os.Stderr, "Usage: %s [OPTION]... PATTERNS [FILE]...\n", progName)
+			fmt.Fprintf(os.Stderr, "Try '%s --help' for more information.\n", progName)
+		}
+		return 2 // 1 means no lines were selected, >1 means error
+	}
+
+	if opts.help {
+		printUsage(out)
+		return 0
+	}
+
+	cfg := &config{
+		out:          out,
+		stdin:        stdin,
+		invert:       opts.invert,
+		onlyMatching: opts.onlyMatching,
+		count:        opts.count,
+		listFiles:    opts.listFiles,
+		quiet:        opts.quiet,
+		withFileName: opts.withFileName(),
+
+		// file:line:column: is what editors jump to, --vimgrep always has all of it
+		lineNumber: opts.lineNumber || opts.column || opts.vimgrep,
+		column:     opts.column || opts.vimgrep,
+		byteOffset: opts.byteOffset,
+		vimgrep:    opts.vimgrep,
+
+		colors: &colors{},
+
+		afterContext:   opts.afterContext,
+		beforeContext:  opts.beforeContext,
+		groupSeparator: opts.groupSeparator,
+		separateGroups: (opts.afterContext >= 0 || opts.beforeContext >= 0) && !opts.noGroupSeparator,
+	}
+
+	// Compile once, the same Regexp is reused for every line of every file
+	// -E is POSIX ERE, so matches are leftmost-longest, -P is PCRE's leftmost-first with lookarounds
+	flags := nfa.Longest
+	switch opts.matcher {
+	case 'F':
+		flags |= nfa.Literal
+	case 'P':
+		flags = nfa.Perl
+	}
+	if opts.ignoreCase {
+		flags |= nfa.FoldCase
+	}
+	if opts.wordRegexp {
+		flags |= nfa.WholeWord
+	}
+	if opts.lineRegexp {
+		flags |= nfa.WholeLine
+	}
+	if isUTF8Locale() {
+		flags |= nfa.UTF8
+	}
+
+	re, err := nfa.CompilePatterns(opts.patternLines(), flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	cfg.re = re
+
+	if opts.color == "always" || opts.color == "auto" && isColorTerminal() {
+		cfg.colors = loadColors()
 	}
 
-	pattern := os.Args[2]
 	found := false
 
-	if len(os.Args) == 3 {
-		found = matchStdin(pattern)
-	} else if os.Args[1] == "-r" {
-		pattern := os.Args[3]
-		dir := os.Args[4]
-		found = matchDir(pattern, dir)
-	} else {
-		for i := 3; i < len(os.Args); i++ {
-			fileName := os.Args[i]
-
-			if matchFile(pattern, "", fileName) {
-				found = true
-			}
+	switch {
+	case len(opts.files) == 0 && opts.recursive:
+		// grep -r without files searches the working directory, names are printed without "./"
+		found = matchDir(cfg, "")
+	case len(opts.files) == 0:
+		found = matchReader(cfg, cfg.stdin, "(standard input)")
+	}
+
+	for _, fileName := range opts.files {
+		// -q knows the answer, the other files are not even opened
+		if found && cfg.quiet {
+			break
 		}
+
+		foundHere := false
+		if opts.recursive && isDir(fileName) {
+			foundHere = matchDir(cfg, fileName)
+		} else {
+			foundHere = matchFile(cfg, fileName)
+		}
+
+		if foundHere {
+			found = true
+		}
+	}
+
+	// Like GNU grep, an error wins over "not found", and over "found" too except with -q,
+	// which stops as soon as a line is selected
+	switch {
+	case found && cfg.quiet:
+		return 0
+	case cfg.failed:
+		return 2
+	case !found:
+		return 1
 	}
 
-	if !found {
-		os.Exit(1)
+	return 0
+}
+
+// isUTF8Locale reports whether the character encoding of the locale is UTF-8, like setlocale(LC_CTYPE, "")
+// the first variable that is set wins: LC_ALL, then LC_CTYPE, then LANG
+func isUTF8Locale() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := os.Getenv(name); locale != "" {
+			// en_US.UTF-8, C.utf8...
+			_, charset, _ := strings.Cut(strings.ToLower(locale), ".")
+			charset, _, _ = strings.Cut(charset, "@")
+			return charset == "utf-8" || charset == "utf8"
+		}
 	}
-	// default exit code is 0 which means success
+
+	return false
 }
 
-func matchDir(pattern string, dir string) bool {
-	dirEntry, err := os.ReadDir(dir)
+func isDir(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+// matchDir searches the files under dir, an unreadable directory is reported and skipped.
+// The names of its files start with dir as given, like GNU grep: "." gives "./a", "d/" gives "d/a".
+// dir "" is the working directory, its names have no prefix at all.
+func matchDir(cfg *config, dir string) bool {
+	path, prefix := ".", ""
+	if dir != "" {
+		path = dir
+		prefix = strings.TrimRight(dir, string(os.PathSeparator)) + string(os.PathSeparator)
+	}
+
+	dirEntry, err := os.ReadDir(path)
 	if err != nil {
+		// The entries read before the error are still searched
 		fmt.Fprintf(os.Stderr, "error: read input dir: %v\n", err)
-		os.Exit(2)
+		cfg.failed = true
 	}
 
 	found := false
 	for _, entry := range dirEntry {
+		// Like GNU grep -r, symbolic links below the operands are not followed, not even to files
+		if entry.Type()&os.ModeSymlink != 0 {
+			continue
+		}
+
+		name := prefix + entry.Name()
+
+		if found && cfg.quiet {
+			break
+		}
+
 		foundHere := false
 		if entry.IsDir() {
-			foundHere = matchDir(pattern, dir+entry.Name()+"/")
+			foundHere = matchDir(cfg, name)
 		} else {
-			foundHere = matchFile(pattern, dir, entry.Name())
+			foundHere = matchFile(cfg, name)
 		}
 
 		if foundHere {
@@ -76,91 +257,137 @@ func matchDir(pattern string, dir string) bool {
 	return found
 }
 
-func matchFile(pattern, dir, fileName string) bool {
-	if dir != "" {
-		fileName = dir + fileName
+func matchFile(cfg *config, fileName string) bool {
+	if fileName == "-" {
+		return matchReader(cfg, cfg.stdin, "(standard input)")
 	}
 
 	file, err := os.Open(fileName)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: read input file: %v\n", err)
-		os.Exit(2)
+		cfg.failed = true
+		return false
 	}
 	defer file.Close()
 
+	return matchReader(cfg, file, fileName)
+}
+
+// matchReader prints the selected lines of r and their context, or what -c, -l, -L and -q ask for instead.
+// name is used as the filename prefix.
+func matchReader(cfg *config, r io.Reader, name string) bool {
 	found := false
-	scanner := bufio.NewScanner(file)
+	count := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	printer := newContextPrinter(cfg, name)
+
+	// Byte offsets for -b: the lines come without their newline, advance has it
+	offset, nextOffset := 0, 0
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := scanLines(data, atEOF)
+		if token != nil {
+			offset, nextOffset = nextOffset, nextOffset+advance
+		}
+		return advance, token, err
+	})
 
 	// scan line by line
 	for scanner.Scan() {
-		line := scanner.Text()
-
-		ok, err := matchLine([]byte(line), pattern)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(2)
+		line := scanner.Bytes()
+
+		// The matches of the line when the output shows them, otherwise the faster Match is enough
+		var matches [][]int
+		selected := false
+		switch {
+		case cfg.onlyMatching || cfg.vimgrep || cfg.colors.selectedMatch != "" || cfg.colors.hasGroups():
+			matches = cfg.findAll(line)
+			selected = len(matches) > 0
+		case cfg.column:
+			if loc := cfg.re.FindIndex(line); loc != nil {
+				matches = [][]int{loc}
+			}
+			selected = len(matches) > 0
+		default:
+			selected = matchLine(line, cfg.re)
+		}
+		if cfg.invert {
+			// -o -v selects lines without matches, so there's nothing to print
+			selected = !selected
 		}
 
-		if ok {
+		if selected {
 			found = true
+			count++
 
-			if dir != "" || len(os.Args) >= 5 {
-				fmt.Printf("%s:%s\n", fileName, line)
-			} else {
-				fmt.Printf("%s\n", line)
+			// The answer is known, no need to read any further
+			if cfg.quiet || cfg.listFiles != 0 {
+				break
 			}
 		}
+
+		if !cfg.count && cfg.listFiles == 0 {
+			printer.next(line, offset, selected, matches)
+		}
+	}
+
+	// What was read before the error still counts
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: read input file: %v\n", err)
+		cfg.failed = true
+	}
+
+	switch {
+	case cfg.quiet:
+	case cfg.listFiles == 'l' && found, cfg.listFiles == 'L' && !found:
+		fmt.Fprintln(cfg.out, cfg.colors.paint(cfg.colors.fileName, name))
+	case cfg.count && cfg.listFiles == 0 && cfg.withFileName:
+		fmt.Fprintf(cfg.out, "%s%s%d\n", cfg.colors.paint(cfg.colors.fileName, name), cfg.colors.paint(cfg.colors.separator, ":"), count)
+	case cfg.count && cfg.listFiles == 0:
+		fmt.Fprintln(cfg.out, count)
 	}
 
 	return found
 }
 
-func matchStdin(pattern string) bool {
-	line, err := io.ReadAll(os.Stdin) // assume we're only dealing with a single line
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: read input text: %v\n", err)
-		os.Exit(2)
+// scanLines is bufio.ScanLines without the \r removal: like GNU grep, a CRLF line ends with \r,
+// so 'a$' doesn't match "a\r\n" and the \r is printed back
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
 	}
-
-	ok, err := matchLine(line, pattern)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(2)
+	if atEOF && len(data) > 0 {
+		// The last line has no newline
+		return len(data), data, nil
 	}
 
-	return ok
+	// Request more data
+	return 0, nil, nil
 }
 
-func matchLine(line []byte, pattern string) (bool, error) {
-	// matched := MatchSequential(line, pattern)
-	// if matched {
-	// 	return true, nil
-	// } else {
-	// 	return false, nil
-	// }
+// findAll returns the matches in line, with the slots of their groups when groups have colors
+func (cfg *config) findAll(line []byte) [][]int {
+	if cfg.colors.hasGroups() {
+		return cfg.re.FindAllSubmatchIndex(line, -1)
+	}
 
-	// matched, _, err := MatchAST(line, pattern)
-	// if err != nil {
-	// 	return false, err
-	// }
+	return cfg.re.FindAllIndex(line, -1)
+}
 
-	// return matched, nil
+func matchLine(line []byte, re *nfa.Regexp) bool {
+	// return MatchSequential(line, re.String())
 
-	// matched, _, err := MatchASTHybrid(line, pattern)
-	// if err != nil {
-	// 	return false, err
-	// }
+	// matched, _, err := MatchAST(line, re.String())
+	// return matched && err == nil
 
-	matched, err := nfa.MatchNFA(line, pattern)
-	if err != nil {
-		return false, err
-	}
+	// matched, _, err := MatchASTHybrid(line, re.String())
+	// return matched && err == nil
 
-	return matched, nil
+	return re.Match(line)
 }
 
 // Actual gnu grep uses
-// - Simple literal strings -> Boyer-Moore
+// - Simple literal strings -> Boyer-Moore (here too with -F, see nfa/literal.go)
 // - Basic regex -> Thompson NFA
 // - Complex regex -> Optimized NFA with DFA conversion
 // There is a heuristic for determining nfa/dfa
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/grep-starter-go/app/nfa"
+)
+
+func TestScanLines(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"a\nb\n", []string{"a", "b"}},
+		{"a\r\nb\r\n", []string{"a\r", "b\r"}},
+		{"a\nb", []string{"a", "b"}},
+		{"\n\n", []string{"", ""}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		scanner := bufio.NewScanner(strings.NewReader(tt.input))
+		scanner.Split(scanLines)
+
+		var got []string
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("scanLines(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+// grep -r doesn't follow symbolic links below its operands, to directories or to files
+func TestMatchDirSkipsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"f", filepath.Join("sub", "g")} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("hello\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{"dirlink": "sub", "filelink": "f", "dangling": "missing"} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Skipf("no symbolic links: %v", err)
+		}
+	}
+
+	var out strings.Builder
+	cfg := &config{
+		re:           nfa.MustCompile("hello"),
+		out:          &out,
+		colors:       &colors{},
+		withFileName: true,
+	}
+
+	if !matchDir(cfg, dir) {
+		t.Errorf("matchDir(%q) found nothing", dir)
+	}
+	if cfg.failed {
+		t.Errorf("matchDir(%q) failed", dir)
+	}
+
+	want := filepath.Join(dir, "f") + ":hello\n" + filepath.Join(dir, "sub", "g") + ":hello\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestWithFileName(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"pat"}, false},
+		{[]string{"pat", file}, false},
+		{[]string{"pat", file, file}, true},
+		{[]string{"-r", "pat"}, true},
+		{[]string{"-r", "pat", file}, false},
+		{[]string{"-r", "pat", dir}, true},
+		{[]string{"pat", dir}, false},
+		{[]string{"--vimgrep", "pat", file}, true},
+	}
+
+	for _, tt := range tests {
+		opts, err := parseArgs(tt.args)
+		if err != nil {
+			t.Fatalf("parseArgs(%q) error: %v", tt.args, err)
+		}
+		if got := opts.withFileName(); got != tt.want {
+			t.Errorf("grep %s: withFileName() = %v, want %v", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+}
+
+// failingReader returns err once the reads before it are used up
+type failingReader struct{ err error }
+
+func (r failingReader) Read([]byte) (int, error) { return 0, r.err }
+
+// 0 when a line is selected, 1 when none is, 2 on an error unless -q already found one.
+// -c, -l and -L change what is printed, not what the status is based on.
+func TestExitStatus(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for name, content := range map[string]string{"a": "foo\nbar\n", "b": "bar\n"} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args   string
+		want   int
+		output string
+	}{
+		{"foo a", 0, "foo\n"},
+		{"baz a", 1, ""},
+		{"-v foo a", 0, "bar\n"},
+		{"-v . a", 1, ""},
+		{"-c foo a b", 0, "a:1\nb:0\n"},
+		{"-c baz a", 1, "0\n"},
+		{"-c -v foo a b", 0, "a:1\nb:1\n"},
+		{"-l bar a b", 0, "a\nb\n"},
+		{"-l o a", 0, "a\n"},
+		{"-l -v foo a b", 0, "a\nb\n"},
+		{"-L foo a b", 0, "b\n"},
+		{"-L bar a b", 0, ""},
+		{"-L baz a b", 1, "a\nb\n"},
+		{"-L -v bar a b", 0, "b\n"},
+		{"-q foo a", 0, ""},
+		{"-q baz a", 1, ""},
+		{"foo a missing", 2, "a:foo\n"},
+		{"-q foo a missing", 0, ""},
+		{"-q foo missing a", 0, ""},
+		{"-q baz a missing", 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			var out strings.Builder
+			if got := run(strings.Fields(tt.args), strings.NewReader(""), &out); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+			if got := out.String(); got != tt.output {
+				t.Errorf("output = %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
+
+// -q and -l have their answer after the first selected line, the rest of the input isn't read
+func TestStopsAfterFirstMatch(t *testing.T) {
+	tests := []struct {
+		args   string
+		want   int
+		output string
+	}{
+		{"-q foo", 0, ""},
+		{"-l foo", 0, "(standard input)\n"},
+		{"-L foo", 0, ""},
+		{"foo", 2, "foo\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			stdin := io.MultiReader(strings.NewReader("foo\n"), failingReader{errors.New("read failed")})
+
+			var out strings.Builder
+			if got := run(strings.Fields(tt.args), stdin, &out); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+			if got := out.String(); got != tt.output {
+				t.Errorf("output = %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
+
+// -o prints each match with the same prefixes a whole line would get, the offset is the match's
+func TestOnlyMatchingPrefixes(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(filepath.Join("d", "e"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"c":                          "id=12 id=345\nnone\nid=6\n",
+		"b":                          "bar\n",
+		filepath.Join("d", "e", "f"): "x id=7\n",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"-o -E id=[0-9]+ c", "id=12\nid=345\nid=6\n"},
+		{"-o -E id=[0-9]+ c b", "c:id=12\nc:id=345\nc:id=6\n"},
+		{"-on -E id=[0-9]+ c b", "c:1:id=12\nc:1:id=345\nc:3:id=6\n"},
+		{"-ob -E id=[0-9]+ c b", "c:0:id=12\nc:6:id=345\nc:18:id=6\n"},
+		{"-onb -E id=[0-9]+ c", "1:0:id=12\n1:6:id=345\n3:18:id=6\n"},
+		{"-or -E id=[0-9]+ d", "d/e/f:id=7\n"},
+		{"-or -E id=[0-9]+ d c", "d/e/f:id=7\nc:id=12\nc:id=345\nc:id=6\n"},
+		{"-oc -E id=[0-9]+ c b", "c:2\nb:0\n"},
+
+		// Empty matches print nothing
+		{"-o -E [0-9]* c b", "c:12\nc:345\nc:6\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			var out strings.Builder
+			if status := run(strings.Fields(tt.args), strings.NewReader(""), &out); status != 0 {
+				t.Errorf("status = %d, want 0", status)
+			}
+			if got := out.String(); got != filepath.FromSlash(tt.want) {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package nfa
+
+// Aho-Corasick
+//
+// Finds many fixed strings in a single pass, with one automaton for all of them instead of
+// one Boyer-Moore search (or one branch of a huge alternation) per string.
+// The automaton is the trie of the literals plus a failure link on every node:
+//
+//	literals: he, she, his, hers
+//
+//	(0) -h-> (1) -e-> (2)he -r-> (8) -s-> (9)hers
+//	 |        └-i-> (6) -s-> (7)his
+//	 └-s-> (3) -h-> (4) -e-> (5)she
+//
+//	fail(4) = 1: after "sh" the text also ends with "h"
+//	fail(5) = 2: after "she" it ends with "he", so "he" is found together with "she"
+//
+// Searching follows trie edges and, when a node has no edge for the next byte, falls back along
+// the failure links until one has. Those falls are resolved once when the automaton is built,
+// so every node has a transition on every byte and the search is one table lookup per byte.
+// Bytes that appear in no literal all behave the same, they share one column of the table (byte classes).
+
+type ahoCorasick struct {
+	classes    [256]int32 // class of each byte, 0 for the bytes that appear in no literal
+	numClasses int32
+	delta      []int32 // delta[node*numClasses+class] is the node reached on a byte of that class
+
+	// per node
+	depth   []int // length of the path from the root
+	literal []int // index of the literal ending here, -1 if none
+	output  []int // nearest node on the failure chain where a literal ends, -1 if none
+
+	lengths []int // length of each literal
+}
+
+// newAhoCorasick builds the automaton, a literal that appears twice keeps its first index.
+// With foldCase both cases of a letter are the same byte class.
+func newAhoCorasick(literals []string, foldCase bool) *ahoCorasick {
+	ac := &ahoCorasick{numClasses: 1}
+
+	if foldCase {
+		lowered := make([]string, len(literals))
+		for i, literal := range literals {
+			lowered[i] = asciiLower(literal)
+		}
+		literals = lowered
+	}
+
+	for _, literal := range literals {
+		for j := 0; j < len(literal); j++ {
+			if b := literal[j]; ac.classes[b] == 0 {
+				ac.classes[b] = ac.numClasses
+				ac.numClasses++
+			}
+		}
+	}
+	if foldCase {
+		for b := 'A'; b <= 'Z'; b++ {
+			ac.classes[b] = ac.classes[b-'A'+'a']
+		}
+	}
+
+	// The trie, -1 in delta is no edge
+	root := ac.addNode(0)
+	for i, literal := range literals {
+		ac.lengths = append(ac.lengths, len(literal))
+
+		node := root
+		for j := 0; j < len(literal); j++ {
+			at := node*int(ac.numClasses) + int(ac.classes[literal[j]])
+			if ac.delta[at] < 0 {
+				ac.delta[at] = int32(ac.addNode(ac.depth[node] + 1))
+			}
+			node = int(ac.delta[at])
+		}
+
+		if ac.literal[node] < 0 {
+			ac.literal[node] = i
+		}
+	}
+
+	// Breadth-first, the failure node is shallower so its row is complete when it's needed
+	fail := make([]int, len(ac.depth))
+	queue := []int{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		row := node * int(ac.numClasses)
+
+		for class := 0; class < int(ac.numClasses); class++ {
+			child := int(ac.delta[row+class])
+			failRow := fail[node] * int(ac.numClasses)
+
+			if child < 0 {
+				// No edge: go where the failure node goes, the root stays put
+				if node == root {
+					ac.delta[row+class] = int32(root)
+				} else {
+					ac.delta[row+class] = ac.delta[failRow+class]
+				}
+				continue
+			}
+
+			f := root
+			if node != root {
+				f = int(ac.delta[failRow+class])
+			}
+			fail[child] = f
+			if ac.literal[f] >= 0 {
+				ac.output[child] = f
+			} else {
+				ac.output[child] = ac.output[f]
+			}
+
+			queue = append(queue, child)
+		}
+	}
+
+	return ac
+}
+
+func (ac *ahoCorasick) addNode(depth int) int {
+	for range ac.numClasses {
+		ac.delta = append(ac.delta, -1)
+	}
+	ac.depth = append(ac.depth, depth)
+	ac.literal = append(ac.literal, -1)
+	ac.output = append(ac.output, -1)
+
+	return len(ac.depth) - 1
+}
+
+// find returns the leftmost occurrence at or after pos accepted by fits, and the index of its literal.
+// Of several occurrences starting at the same position the longest wins with longest,
+// otherwise the one with the lowest index. start is -1 if there is none.
+//
+// Occurrences are reported by their end, so the first one found is not necessarily leftmost:
+// with literals "abcd" and "bc", "bc" is found first in "abcd". The search goes on while
+// the partial match in progress (the path to the current node) could still start at or before the best one.
+func (ac *ahoCorasick) find(input []byte, pos int, longest bool, fits func(start int, end int) bool) (start int, end int, literal int) {
+	start, end, literal = -1, -1, -1
+
+	consider := func(node int, at int) {
+		for ; node >= 0; node = ac.output[node] {
+			i := ac.literal[node]
+			if i < 0 {
+				continue
+			}
+
+			s := at - ac.lengths[i]
+			better := start < 0 || s < start ||
+				s == start && (longest && at > end || !longest && i < literal)
+			if better && fits(s, at) {
+				start, end, literal = s, at, i
+			}
+		}
+	}
+
+	node := 0
+	consider(node, pos) // the empty literal
+	for i := pos; i < len(input); i++ {
+		if start >= 0 && i-ac.depth[node] > start {
+			break
+		}
+
+		node = int(ac.delta[node*int(ac.numClasses)+int(ac.classes[input[i]])])
+		consider(node, i+1)
+	}
+
+	return start, end, literal
+}
@@ -0,0 +1,112 @@
+package nfa
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestAhoCorasick(t *testing.T) {
+	tests := []struct {
+		name      string
+		literals  []string
+		longest   bool
+		input     string
+		wantStart int
+		wantEnd   int
+		wantIndex int
+	}{
+		// "bc" ends first, "abcd" starts first
+		{"longer literal starting earlier", []string{"abcd", "bc"}, false, "xabcdx", 1, 5, 0},
+		{"longer literal starting earlier, longest", []string{"abcd", "bc"}, true, "xabcdx", 1, 5, 0},
+		{"longer literal only partly there", []string{"abcd", "bc"}, false, "xabcx", 2, 4, 1},
+		{"same start, first wins", []string{"ab", "abc"}, false, "abc", 0, 2, 0},
+		{"same start, longest wins", []string{"ab", "abc"}, true, "abc", 0, 3, 1},
+		{"suffix of another", []string{"bcd", "cd"}, false, "abcd", 1, 4, 0},
+		{"none", []string{"foo", "bar"}, false, "baz", -1, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac := newAhoCorasick(tt.literals, false)
+			start, end, index := ac.find([]byte(tt.input), 0, tt.longest, func(int, int) bool { return true })
+			if start != tt.wantStart || start >= 0 && (end != tt.wantEnd || index != tt.wantIndex) {
+				t.Errorf("find(%q) = %d, %d, %d, want %d, %d, %d", tt.input, start, end, index, tt.wantStart, tt.wantEnd, tt.wantIndex)
+			}
+		})
+	}
+}
+
+// The searcher against a naive scan, on random literals and inputs over a small alphabet
+func TestLiteralSearcherRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	word := func(maxLen int) string {
+		b := make([]byte, 1+rng.Intn(maxLen))
+		for i := range b {
+			b[i] = "abc"[rng.Intn(3)]
+		}
+		return string(b)
+	}
+
+	for range 2000 {
+		literals := make([]string, 1+rng.Intn(4))
+		for i := range literals {
+			literals[i] = word(4)
+		}
+		input := []byte(word(12))
+		longest := rng.Intn(2) == 0
+
+		flags := Flags(0)
+		if longest {
+			flags = Longest
+		}
+		slots, index := newLiteralSearcher(literals, flags).find(input, 0)
+
+		// Leftmost, then longest or first
+		wantStart, wantIndex := -1, -1
+		for start := 0; start < len(input) && wantStart < 0; start++ {
+			for i, literal := range literals {
+				if !bytes.HasPrefix(input[start:], []byte(literal)) {
+					continue
+				}
+				if wantIndex < 0 || longest && len(literal) > len(literals[wantIndex]) {
+					wantStart, wantIndex = start, i
+				}
+			}
+		}
+
+		if wantStart < 0 {
+			if slots != nil {
+				t.Fatalf("find(%q) in %q = %v, want no match", literals, input, slots)
+			}
+			continue
+		}
+		if slots == nil || slots[0] != wantStart || slots[1] != wantStart+len(literals[wantIndex]) ||
+			literals[index] != literals[wantIndex] {
+			t.Fatalf("find(%q) in %q, longest %v = %v %d, want %d %q", literals, input, longest, slots, index,
+				wantStart, literals[wantIndex])
+		}
+	}
+}
+
+func TestEreLiterals(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string // nil when it's not only literals
+	}{
+		{"error|warn|fatal", []string{"error", "warn", "fatal"}},
+		{`a\.b|c\|d`, []string{"a.b", "c|d"}},
+		{"a||b", nil},
+		{"a.b", nil},
+		{`\d`, nil},
+		{"(a|b)", nil},
+	}
+
+	for _, tt := range tests {
+		got, ok := ereLiterals(tt.pattern)
+		if ok != (tt.want != nil) || strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("ereLiterals(%q) = %q, %v, want %q", tt.pattern, got, ok, tt.want)
+		}
+	}
+}
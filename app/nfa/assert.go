@@ -0,0 +1,196 @@
+package nfa
+
+import "unicode/utf8"
+
+// Zero-width assertions
+//
+// An assertion is an ε-transition that can only be taken when the bytes around the current
+// position look right. It consumes nothing, so it only ever needs the byte before and the byte after:
+//
+//	input:  "a cat"
+//	           ^     \b holds here: before is ' ', after is 'c'
+//
+// The input is a single line, so ^ and $ are the same as \A and \z.
+//
+// The engines evaluate them differently:
+//   - NFA.Run and the Pike VM look at input[pos-1] and input[pos] while following ε-transitions,
+//     or decode the runes before and after pos for the Unicode word assertions
+//   - the lazy DFA remembers the class of the previous byte in each DFA state and resolves
+//     assertions once the next byte is known, when it computes a transition.
+//     A single byte doesn't tell whether a rune is a word character, so Unicode word
+//     assertions are left to the Pike VM.
+
+// AssertKind is the condition checked by an AssertMatcher
+type AssertKind uint8
+
+const (
+	AssertWordBoundary    AssertKind = iota // \b  a word byte on exactly one side
+	AssertNotWordBoundary                   // \B  word bytes on both sides or on neither
+	AssertWordStart                         // \<  a word byte after, none before
+	AssertWordEnd                           // \>  a word byte before, none after
+	AssertBeginText                         // ^ \A  at the start of the input
+	AssertEndText                           // $ \z  at the end of the input
+	AssertNotWordBefore                     // no word byte before, the start of a WholeWord match
+	AssertNotWordAfter                      // no word byte after, the end of a WholeWord match
+)
+
+// byteClass is everything an assertion needs to know about a byte next to the position
+type byteClass uint8
+
+const (
+	classEdge  byteClass = iota // before the first byte or after the last one
+	classWord                   // [A-Za-z0-9_], word bytes are ASCII even in UTF-8 mode
+	classOther                  // any other byte
+)
+
+func classOfByte(b byte) byteClass {
+	if b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' {
+		return classWord
+	}
+
+	return classOther
+}
+
+// classAt is the class of input[i], positions outside the input are edges
+func classAt(input []byte, i int) byteClass {
+	if i < 0 || i >= len(input) {
+		return classEdge
+	}
+
+	return classOfByte(input[i])
+}
+
+// holds reports whether the assertion is true between a byte of class before and one of class after
+func (k AssertKind) holds(before byteClass, after byteClass) bool {
+	switch k {
+	case AssertWordBoundary:
+		return (before == classWord) != (after == classWord)
+	case AssertNotWordBoundary:
+		return (before == classWord) == (after == classWord)
+	case AssertWordStart:
+		return before != classWord && after == classWord
+	case AssertWordEnd:
+		return before == classWord && after != classWord
+	case AssertBeginText:
+		return before == classEdge
+	case AssertEndText:
+		return after == classEdge
+	case AssertNotWordBefore:
+		return before != classWord
+	case AssertNotWordAfter:
+		return after != classWord
+	default:
+		return false
+	}
+}
+
+// classOfRune is classOfByte for a whole rune, word runes are the locale's, see localeWordRunes
+func classOfRune(r rune) byteClass {
+	if r < utf8.RuneSelf {
+		return classOfByte(byte(r))
+	}
+	if isLocaleWordRune(r) {
+		return classWord
+	}
+
+	return classOther
+}
+
+// runeClassBefore is the class of the rune ending at input[pos-1], invalid UTF-8 is classOther
+func runeClassBefore(input []byte, pos int) byteClass {
+	if pos <= 0 || pos > len(input) {
+		return classEdge
+	}
+
+	r, _ := utf8.DecodeLastRune(input[:pos])
+	return classOfRune(r)
+}
+
+// runeClassAt is the class of the rune starting at input[pos]
+func runeClassAt(input []byte, pos int) byteClass {
+	if pos < 0 || pos >= len(input) {
+		return classEdge
+	}
+
+	r, _ := utf8.DecodeRune(input[pos:])
+	return classOfRune(r)
+}
+
+// AssertMatcher is an ε-transition guarded by a zero-width assertion.
+// Unicode word assertions look at the runes around the position instead of the bytes,
+// in "café" there is no word boundary between "caf" and "é".
+type AssertMatcher struct {
+	Kind    AssertKind
+	Unicode bool
+}
+
+func (m AssertMatcher) Match(input []byte, ex *ExecutionContext) bool {
+	return m.holdsAt(input, ex.Pos)
+}
+
+// holdsAt reports whether the assertion is true at input position pos
+func (m AssertMatcher) holdsAt(input []byte, pos int) bool {
+	if m.Unicode {
+		return m.Kind.holds(runeClassBefore(input, pos), runeClassAt(input, pos))
+	}
+
+	return m.Kind.holds(classAt(input, pos-1), classAt(input, pos))
+}
+
+func (m AssertMatcher) IsEpsilon() bool {
+	return true
+}
+
+// isAnchoredStart reports whether every path from Start begins with ^ or \A,
+// then matches can only start at position 0 and the engines don't try any other
+func (nfa *NFA) isAnchoredStart() bool {
+	visited := make([]bool, nfa.NumStates)
+	stack := []*State{nfa.Start}
+	anchored := true
+
+	for len(stack) > 0 && anchored {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if visited[state.ID] {
+			continue
+		}
+		visited[state.ID] = true
+
+		if state.IsAccept {
+			anchored = false
+		}
+
+		for _, transition := range state.Transitions {
+			matcher, isAssert := transition.Matcher.(AssertMatcher)
+			switch {
+			case isAssert && matcher.Kind == AssertBeginText:
+				// this path is anchored
+
+			case transition.Matcher.IsEpsilon() && !isAssert:
+				stack = append(stack, transition.Target)
+
+			default:
+				anchored = false
+			}
+		}
+	}
+
+	return anchored
+}
+
+// buildAssertNFA creates the fragment q₀ --assert-→ q₁ (accept)
+func (p *NFAParser) buildAssertNFA(kind AssertKind) *NFA {
+	q0 := p.NewState() // Start state
+	q1 := p.NewState() // Accept state
+	q1.IsAccept = true
+
+	// ^ and $ only look for the edges, which are the same for bytes and runes
+	unicode := p.localeClasses && kind != AssertBeginText && kind != AssertEndText
+	q0.AddTransition(q1, AssertMatcher{Kind: kind, Unicode: unicode})
+
+	return &NFA{
+		Start:  q0,
+		Accept: q1,
+	}
+}
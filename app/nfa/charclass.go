@@ -0,0 +1,150 @@
+package nfa
+
+import "slices"
+
+// Character class range sets
+//
+// A bracket expression is stored as sorted, non-overlapping, non-adjacent byte ranges,
+// so membership is a binary search instead of a scan over every listed byte.
+//
+//	[a-fx0-9_]  ->  [0-9] [_] [a-f] [x]
+//	\w          ->  [0-9] [A-Z] [_] [a-z]
+
+// ByteRange is the inclusive range of bytes [Lo, Hi]
+type ByteRange struct {
+	Lo byte
+	Hi byte
+}
+
+var (
+	digitRanges = []ByteRange{{'0', '9'}}
+	wordRanges  = []ByteRange{{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}}
+	spaceRanges = []ByteRange{{'\t', '\r'}, {' ', ' '}} // \t \n \v \f \r and space
+)
+
+// posixClasses are the [:name:] classes of the C locale
+var posixClasses = map[string][]ByteRange{
+	"alnum":  {{'0', '9'}, {'A', 'Z'}, {'a', 'z'}},
+	"alpha":  {{'A', 'Z'}, {'a', 'z'}},
+	"blank":  {{'\t', '\t'}, {' ', ' '}},
+	"cntrl":  {{0x00, 0x1f}, {0x7f, 0x7f}},
+	"digit":  {{'0', '9'}},
+	"graph":  {{'!', '~'}},
+	"lower":  {{'a', 'z'}},
+	"print":  {{' ', '~'}},
+	"punct":  {{'!', '/'}, {':', '@'}, {'[', '`'}, {'{', '~'}},
+	"space":  {{'\t', '\r'}, {' ', ' '}},
+	"upper":  {{'A', 'Z'}},
+	"xdigit": {{'0', '9'}, {'A', 'F'}, {'a', 'f'}},
+}
+
+// collatingNames are the multi-character [.name.] collating symbols,
+// the names POSIX gives to the portable character set.
+// In the C locale every collating element is a single byte, so [.x.] is just x.
+var collatingNames = map[string]byte{
+	"NUL": 0x00, "alert": '\a', "backspace": '\b', "tab": '\t', "newline": '\n',
+	"vertical-tab": '\v', "form-feed": '\f', "carriage-return": '\r', "DEL": 0x7f,
+	"space": ' ', "exclamation-mark": '!', "quotation-mark": '"', "number-sign": '#',
+	"dollar-sign": '$', "percent-sign": '%', "ampersand": '&', "apostrophe": '\'',
+	"left-parenthesis": '(', "right-parenthesis": ')', "asterisk": '*', "plus-sign": '+',
+	"comma": ',', "hyphen": '-', "hyphen-minus": '-', "period": '.', "full-stop": '.',
+	"slash": '/', "solidus": '/', "zero": '0', "one": '1', "two": '2', "three": '3',
+	"four": '4', "five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+	"colon": ':', "semicolon": ';', "less-than-sign": '<', "equals-sign": '=',
+	"greater-than-sign": '>', "question-mark": '?', "commercial-at": '@',
+	"left-square-bracket": '[', "backslash": '\\', "reverse-solidus": '\\',
+	"right-square-bracket": ']', "circumflex": '^', "circumflex-accent": '^',
+	"underscore": '_', "low-line": '_', "grave-accent": '`', "left-brace": '{',
+	"left-curly-bracket": '{', "vertical-line": '|', "right-brace": '}',
+	"right-curly-bracket": '}', "tilde": '~',
+}
+
+// normalizeRanges sorts the ranges and merges the ones that overlap or touch
+func normalizeRanges(ranges []ByteRange) []ByteRange {
+	if len(ranges) == 0 {
+		return ranges
+	}
+
+	slices.SortFunc(ranges, func(a, b ByteRange) int { return int(a.Lo) - int(b.Lo) })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if int(r.Lo) <= int(last.Hi)+1 {
+			last.Hi = max(last.Hi, r.Hi)
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// negateRanges returns every byte not in the normalized ranges
+func negateRanges(ranges []ByteRange) []ByteRange {
+	var negated []ByteRange
+	next := 0 // first byte not covered yet
+
+	for _, r := range ranges {
+		if int(r.Lo) > next {
+			negated = append(negated, ByteRange{byte(next), r.Lo - 1})
+		}
+		next = int(r.Hi) + 1
+	}
+
+	if next <= 0xff {
+		negated = append(negated, ByteRange{byte(next), 0xff})
+	}
+
+	return negated
+}
+
+// foldRanges adds the other case of every ASCII letter in the ranges
+func foldRanges(ranges []ByteRange) []ByteRange {
+	folded := slices.Clone(ranges)
+
+	for _, r := range ranges {
+		// Intersect with a-z and A-Z, shift what's left to the other case
+		if lo, hi := max(r.Lo, 'a'), min(r.Hi, 'z'); lo <= hi {
+			folded = append(folded, ByteRange{lo - 'a' + 'A', hi - 'a' + 'A'})
+		}
+		if lo, hi := max(r.Lo, 'A'), min(r.Hi, 'Z'); lo <= hi {
+			folded = append(folded, ByteRange{lo - 'A' + 'a', hi - 'A' + 'a'})
+		}
+	}
+
+	return normalizeRanges(folded)
+}
+
+// containsByte binary searches the normalized ranges
+func containsByte(ranges []ByteRange, b byte) bool {
+	_, found := slices.BinarySearchFunc(ranges, b, func(r ByteRange, b byte) int {
+		switch {
+		case r.Hi < b:
+			return -1
+		case r.Lo > b:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return found
+}
+
+// shorthandRanges returns the ranges of \d \w \s, negated is set for \D \W \S.
+// ok is false for any other escape.
+func shorthandRanges(symbol byte) (ranges []ByteRange, negated bool, ok bool) {
+	switch symbol {
+	case 'd', 'D':
+		ranges = digitRanges
+	case 'w', 'W':
+		ranges = wordRanges
+	case 's', 'S':
+		ranges = spaceRanges
+	default:
+		return nil, false, false
+	}
+
+	return ranges, symbol < 'a', true
+}
@@ -0,0 +1,102 @@
+package nfa
+
+import (
+	"slices"
+	"testing"
+)
+
+// findAllStrings returns the text of every match of pattern in input
+func findAllStrings(t *testing.T, pattern string, flags Flags, input string) []string {
+	t.Helper()
+
+	re, err := CompileFlags(pattern, flags)
+	if err != nil {
+		t.Fatalf("CompileFlags(%q) error: %v", pattern, err)
+	}
+
+	var matches []string
+	for _, loc := range re.FindAllIndex([]byte(input), -1) {
+		matches = append(matches, input[loc[0]:loc[1]])
+	}
+
+	return matches
+}
+
+func TestBracketExpressions(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    []string // FindAllIndex as text
+	}{
+		{`[a-c]+`, "xabcdcb", []string{"abc", "cb"}},
+		{`[a-cx-z0-9]+`, "ay9-bw", []string{"ay9", "b"}},
+		{`[^a-c]+`, "abxyc", []string{"xy"}},
+		{`[]a]+`, "x]a]y", []string{"]a]"}},
+		{`[^]a]+`, "]xa]y", []string{"x", "y"}},
+		{`[a-]+`, "x-a-y", []string{"-a-"}},
+		{`[-a]+`, "x-a-y", []string{"-a-"}},
+		{`[\d]+`, "a12b3", []string{"12", "3"}},
+		{`[^\d]+`, "a12b3", []string{"a", "b"}},
+		{`[\w-]+`, "a-b c", []string{"a-b", "c"}},
+		{`[^\s]+`, "a b\tc", []string{"a", "b", "c"}},
+		{`[\]]+`, "a]]b", []string{"]]"}},
+		{`[\\]`, `a\b`, []string{`\`}},
+		{`[.*+?]+`, "a.*b+?", []string{".*", "+?"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got := findAllStrings(t, tt.pattern, Longest, tt.input)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matches in %q = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBracketExpressionErrors(t *testing.T) {
+	for _, pattern := range []string{`[a`, `[]`, `[^]`, `[z-a]`, `[\d-z]`, `[a-\d]`, `[a\`} {
+		if _, err := CompileFlags(pattern, Longest); err == nil {
+			t.Errorf("CompileFlags(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestPOSIXBracketSymbols(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    []string // FindAllIndex as text
+	}{
+		{`[[:xdigit:]]+`, "0x1fG9aZ", []string{"0", "1f", "9a"}},
+		{`[[:alpha:]]+`, "ab1CD_e", []string{"ab", "CD", "e"}},
+		{`[[:digit:][:upper:]]+`, "aB1cD", []string{"B1", "D"}},
+		{`[^[:alnum:]]+`, "a-_b c", []string{"-_", " "}},
+		{`[[:space:]]+`, "a \t\vb", []string{" \t\v"}},
+		{`[[:punct:]]+`, "a.,b!", []string{".,", "!"}},
+		{`[[:lower:]-]+`, "a-bC", []string{"a-b"}},
+		{`[[=a=]]+`, "baab", []string{"aa"}},
+		{`[[=a=]b]+`, "cabac", []string{"aba"}},
+		{`[[.hyphen.]]`, "a-b", []string{"-"}},
+		{`[[.-.]a]+`, "a-b", []string{"a-"}},
+		{`[[.space.][.tab.]]+`, "a \tb", []string{" \t"}},
+		{`[[.a.]-c]+`, "xabcd", []string{"abc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got := findAllStrings(t, tt.pattern, Longest, tt.input)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matches in %q = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPOSIXBracketSymbolErrors(t *testing.T) {
+	for _, pattern := range []string{`[[:word:]]`, `[[:alpha]]`, `[[=ab=]]`, `[[.nope.]]`, `[[:alpha:]-z]`, `[[=a`} {
+		if _, err := CompileFlags(pattern, Longest); err == nil {
+			t.Errorf("CompileFlags(%q) succeeded, want an error", pattern)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package nfa
+
+import (
+	"encoding/binary"
+	"slices"
+	"sync"
+)
+
+// Lazy DFA
+//
+// Subset construction turns the NFA into a DFA whose states are sets of NFA states,
+// so matching is a single table lookup per input byte with no contexts to clone.
+// Building every DFA state upfront can blow up exponentially, so states are created
+// the first time a search reaches them and each transition is cached once computed
+// (the same trick GNU grep's dfa.c and RE2 use).
+//
+//	pattern: a(b|c)
+//	{q0} --a--> {q2, q4} --b--> {q7 accept}
+//	                     └--c--> {q7 accept}
+//
+// The DFA only answers "is there a match", captures need the NFA simulation.
+// It cannot handle backreferences either, those are not regular.
+//
+// Assertions (\b...) depend on the bytes around the position, which a set of NFA states
+// alone doesn't know. Like RE2, each DFA state also records the class of the byte consumed
+// to reach it, and assertions are resolved when the transition on the next byte is computed.
+// So whether a state accepts depends on the next byte, and is cached next to that transition.
+
+const (
+	maxDFAStates  = 2048 // once the cache holds this many states it is flushed
+	maxDFAFlushes = 8    // a search that keeps flushing is thrashing, let the NFA take over
+)
+
+// dfaState is one set of NFA states (after ε-closure)
+type dfaState struct {
+	states []*State       // only states with byte transitions, assertions or accepting, sorted by ID
+	before byteClass      // class of the byte consumed to get here, classEdge at the start of the input
+	next   [256]*dfaState // nil until the transition on that byte is computed
+
+	acceptBefore [4]uint64 // bit b: accepting here when the next byte is b, set along with next[b]
+	endKnown     bool
+	acceptAtEnd  bool // accepting here when the input ends, computed once endKnown
+}
+
+// assertContext are the classes on both sides of the position, to resolve assertions
+type assertContext struct {
+	before byteClass
+	after  byteClass
+}
+
+// lazyDFA is shared by every goroutine using the Regexp.
+// The mutable part (the state cache) lives in a dfaCache borrowed from the pool for each search.
+type lazyDFA struct {
+	nfa           *NFA
+	anchored      bool // matches can only start at position 0, see isAnchoredStart
+	hasAssertions bool // without assertions the byte classes are not part of the state
+	pool          sync.Pool
+}
+
+type dfaCache struct {
+	states map[string]*dfaState // key is the encoded set of NFA state IDs
+	start  *dfaState
+	dead   *dfaState // empty set, nothing can match from here
+
+	// scratch space for computing transitions
+	visited []bool
+	touched []int // IDs set in visited, to clear them without scanning the whole slice
+	stack   []*State
+	targets []*State
+	key     []byte
+}
+
+// newLazyDFA returns nil when the NFA has transitions the DFA cannot precompute:
+// backreferences, and lookarounds, whose condition depends on more than the next byte
+func newLazyDFA(nfa *NFA, anchored bool) *lazyDFA {
+	if !nfa.isRegular() {
+		return nil
+	}
+
+	hasLookaround := false
+	nfa.walk(func(state *State) {
+		for _, transition := range state.Transitions {
+			if _, ok := transition.Matcher.(*LookMatcher); ok {
+				hasLookaround = true
+			}
+		}
+	})
+	if hasLookaround {
+		return nil
+	}
+
+	d := &lazyDFA{
+		nfa:      nfa,
+		anchored: anchored,
+	}
+	hasUnicodeAssertions := false
+	nfa.walk(func(state *State) {
+		for _, transition := range state.Transitions {
+			if matcher, ok := transition.Matcher.(AssertMatcher); ok {
+				d.hasAssertions = true
+				hasUnicodeAssertions = hasUnicodeAssertions || matcher.Unicode
+			}
+		}
+	})
+	// The class of the previous byte can't tell whether the rune it ends is a word character
+	if hasUnicodeAssertions {
+		return nil
+	}
+	d.pool.New = func() any {
+		c := &dfaCache{visited: make([]bool, nfa.NumStates)}
+		c.reset(d)
+		return c
+	}
+
+	return d
+}
+
+// match reports whether the input contains a match.
+// ok is false if the DFA gave up because its cache kept overflowing.
+func (d *lazyDFA) match(input []byte) (matched bool, ok bool) {
+	c := d.pool.Get().(*dfaCache)
+	defer d.pool.Put(c)
+
+	flushes := 0
+	curr := c.start
+
+	for i := 0; ; i++ {
+		if i == len(input) {
+			return c.acceptsAtEnd(d, curr), true
+		}
+
+		if curr == c.dead {
+			return false, true
+		}
+
+		b := input[i]
+		next := curr.next[b]
+		if next == nil {
+			if len(c.states) >= maxDFAStates {
+				flushes++
+				if flushes > maxDFAFlushes {
+					return false, false
+				}
+
+				curr = c.flush(d, curr)
+			}
+
+			next = c.step(d, curr, b)
+			curr.next[b] = next
+		}
+
+		// The first accepting state is enough, $ is an assertion that only holds at the end
+		if curr.acceptBefore[b/64]&(1<<(b%64)) != 0 {
+			return true, true
+		}
+
+		curr = next
+	}
+}
+
+// reset empties the cache, keeping only the start and dead states
+func (c *dfaCache) reset(d *lazyDFA) {
+	c.states = make(map[string]*dfaState)
+	c.dead = &dfaState{endKnown: true}
+	c.start = c.lookup(d, c.closure([]*State{d.nfa.Start}, nil), classEdge)
+}
+
+// flush resets the cache and re-adds the state the search is currently in.
+// States from before the flush are dropped, including their cached transitions.
+func (c *dfaCache) flush(d *lazyDFA, curr *dfaState) *dfaState {
+	states, before := curr.states, curr.before
+	c.reset(d)
+
+	return c.lookup(d, states, before)
+}
+
+// step computes δ(curr, b): every NFA state reachable by consuming b, then its ε-closure.
+// It also records whether curr accepts when b is the next byte.
+func (c *dfaCache) step(d *lazyDFA, curr *dfaState, b byte) *dfaState {
+	after := classOfByte(b)
+	resolved := c.resolve(d, curr, after)
+
+	targets := c.targets[:0]
+	for _, state := range resolved {
+		if state.IsAccept {
+			curr.acceptBefore[b/64] |= 1 << (b % 64)
+		}
+
+		for _, transition := range state.Transitions {
+			if matcher, ok := transition.Matcher.(ByteMatcher); ok && matcher.MatchByte(b) {
+				targets = append(targets, transition.Target)
+			}
+		}
+	}
+
+	// Unanchored search: a new match attempt can start at every position
+	if !d.anchored {
+		targets = append(targets, d.nfa.Start)
+	}
+	c.targets = targets
+
+	return c.lookup(d, c.closure(targets, nil), after)
+}
+
+// resolve follows the assertions of ds that hold when the next byte is of class after
+func (c *dfaCache) resolve(d *lazyDFA, ds *dfaState, after byteClass) []*State {
+	if !d.hasAssertions {
+		return ds.states // already closed
+	}
+
+	return c.closure(ds.states, &assertContext{before: ds.before, after: after})
+}
+
+// acceptsAtEnd reports whether ds accepts when there is no next byte
+func (c *dfaCache) acceptsAtEnd(d *lazyDFA, ds *dfaState) bool {
+	if !ds.endKnown {
+		for _, state := range c.resolve(d, ds, classEdge) {
+			if state.IsAccept {
+				ds.acceptAtEnd = true
+			}
+		}
+		ds.endKnown = true
+	}
+
+	return ds.acceptAtEnd
+}
+
+// closure computes the ε-closure of the given states, keeping only the ones that matter
+// for the DFA state identity (byte transitions, assertions or accepting), sorted by ID.
+// Assertions are only followed when ctx is set and they hold in it.
+func (c *dfaCache) closure(states []*State, ctx *assertContext) []*State {
+	var closure []*State
+	stack := append(c.stack[:0], states...)
+
+	for len(stack) > 0 {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if c.visited[state.ID] {
+			continue
+		}
+		c.visited[state.ID] = true
+		c.touched = append(c.touched, state.ID)
+
+		important := state.IsAccept
+		for _, transition := range state.Transitions {
+			matcher, isAssert := transition.Matcher.(AssertMatcher)
+			switch {
+			case isAssert && ctx == nil:
+				important = true
+
+			case isAssert:
+				if matcher.Kind.holds(ctx.before, ctx.after) {
+					stack = append(stack, transition.Target)
+				}
+
+			case transition.Matcher.IsEpsilon():
+				stack = append(stack, transition.Target)
+
+			default:
+				important = true
+			}
+		}
+
+		if important {
+			closure = append(closure, state)
+		}
+	}
+	c.stack = stack
+
+	for _, id := range c.touched {
+		c.visited[id] = false
+	}
+	c.touched = c.touched[:0]
+
+	slices.SortFunc(closure, func(a, b *State) int { return a.ID - b.ID })
+	return closure
+}
+
+// lookup returns the cached DFA state for the set of NFA states, creating it if needed
+func (c *dfaCache) lookup(d *lazyDFA, states []*State, before byteClass) *dfaState {
+	if len(states) == 0 {
+		return c.dead
+	}
+	if !d.hasAssertions {
+		before = classEdge // nothing looks at it, don't split states on it
+	}
+
+	key := append(c.key[:0], byte(before))
+	for _, state := range states {
+		key = binary.AppendUvarint(key, uint64(state.ID))
+	}
+	c.key = key
+
+	if cached, ok := c.states[string(key)]; ok {
+		return cached
+	}
+
+	ds := &dfaState{states: states, before: before}
+	c.states[string(key)] = ds
+	return ds
+}
@@ -0,0 +1,65 @@
+package nfa
+
+import "testing"
+
+// Patterns and inputs every engine is checked on against the ExecutionContext simulation (Run)
+var enginePatterns = []string{
+	`abc`,
+	`a|ab`,
+	`(a|ab)(c|bcd)(d*)`,
+	`(a*)(ab)*(b*)`,
+	`x*`,
+	`(a+|b+)*c`,
+	`^ab`,
+	`ab$`,
+	`^$`,
+	`[a-c]+d?`,
+	`[^a]b`,
+	`(a|b)*abb`,
+	`((a)|b)+`,
+	`a{2,3}`,
+	`(ab){2}|a`,
+	`\bab\b`,
+	`\<a`,
+	`a.c`,
+	`(a?)+b`,
+	`(?<=a)b`,
+	`a(?=c)`,
+	`(?<!a)b`,
+}
+
+var engineInputs = []string{
+	"", "a", "ab", "abc", "abcd", "xabcdx", "aab", "babb", "aabb", "aaa", "abab", "ba", "cab", "a c", "abb abb", "ac",
+}
+
+// runSlots returns the slots of the leftmost match found by Run, nil if there is none
+func runSlots(re *Regexp, input []byte) []int {
+	for pos := 0; pos <= len(input); pos++ {
+		if result := re.nfa.Run(input, pos, re.flags&Longest != 0); result.Matched {
+			return result.slots(re.nfa.NumGroups)
+		}
+	}
+
+	return nil
+}
+
+func TestDFAAgreesWithRun(t *testing.T) {
+	for _, flags := range []Flags{Longest | Perl, Perl} {
+		for _, pattern := range enginePatterns {
+			re, err := CompileFlags(pattern, flags)
+			if err != nil {
+				t.Fatalf("CompileFlags(%q) error: %v", pattern, err)
+			}
+			if re.dfa == nil {
+				continue
+			}
+
+			for _, input := range engineInputs {
+				want := runSlots(re, []byte(input)) != nil
+				if matched, ok := re.dfa.match([]byte(input)); ok && matched != want {
+					t.Errorf("%q on %q, longest %v: DFA %v, Run %v", pattern, input, flags&Longest != 0, matched, want)
+				}
+			}
+		}
+	}
+}
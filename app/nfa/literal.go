@@ -0,0 +1,339 @@
+package nfa
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Literal search
+//
+// Fixed strings (the Literal flag, grep -F, or an ERE like error|warn|fatal) don't need an automaton
+// to be found. A single one is searched with Boyer-Moore, several with Aho-Corasick (ahocorasick.go).
+// Boyer-Moore compares the pattern right to left against the text and, on a mismatch,
+// shifts it by the larger of two precomputed skips:
+//
+//	text:    here is a simple example
+//	pattern: example
+//	               ^ 's' is not in "example" at all, shift by 7 without looking at the bytes in between
+//
+//   - bad character: align the mismatched text byte with its last occurrence in the pattern
+//   - good suffix: align the suffix matched so far with its previous occurrence in the pattern
+//
+// Most positions of typical text are never looked at, the longer the pattern the fewer (sublinear).
+// With FoldCase both tables and every comparison use lowercased bytes, so folding is ASCII only:
+// in UTF-8 mode a pattern with other letters (é, or k which also folds to U+212A KELVIN SIGN)
+// goes through the automaton engines instead.
+//
+// The Regexp still gets an NFA of the literals (parseLiteral for Literal patterns), the searcher is only
+// the engine that runs when no captures are involved, which fixed strings never have.
+
+// boyerMoore finds one fixed string
+type boyerMoore struct {
+	pattern  []byte // lowercased with foldCase
+	foldCase bool
+
+	// badCharSkip[b] is how far the text can shift when its byte b mismatches the last pattern byte:
+	// the distance from the last occurrence of b in pattern[:len-1] to the end, or len(pattern)
+	badCharSkip [256]int
+
+	// goodSuffixSkip[i] is how far the text can shift when pattern[i+1:] matched but pattern[i] didn't
+	goodSuffixSkip []int
+}
+
+func newBoyerMoore(pattern string, foldCase bool) *boyerMoore {
+	f := &boyerMoore{
+		pattern:        []byte(pattern),
+		foldCase:       foldCase,
+		goodSuffixSkip: make([]int, len(pattern)),
+	}
+	if foldCase {
+		f.pattern = []byte(asciiLower(pattern))
+	}
+	pat := string(f.pattern)
+	last := len(pat) - 1
+
+	for i := range f.badCharSkip {
+		f.badCharSkip[i] = len(pat)
+	}
+	for i := 0; i < last; i++ {
+		f.badCharSkip[pat[i]] = last - i
+		if other, ok := swapCase(pat[i]); ok && foldCase {
+			f.badCharSkip[other] = last - i
+		}
+	}
+
+	// The matched suffix also occurs as a prefix: shift so that prefix lines up with it
+	lastPrefix := last
+	for i := last; i >= 0; i-- {
+		if strings.HasPrefix(pat, pat[i+1:]) {
+			lastPrefix = i + 1
+		}
+		f.goodSuffixSkip[i] = lastPrefix + last - i
+	}
+
+	// The matched suffix occurs somewhere else, preceded by a different byte: shift to that occurrence
+	for i := 0; i < last; i++ {
+		suffix := commonSuffixLength(pat, pat[1:i+1])
+		if pat[i-suffix] != pat[last-suffix] {
+			f.goodSuffixSkip[last-suffix] = suffix + last - i
+		}
+	}
+
+	return f
+}
+
+// next returns the offset of the first occurrence of the pattern in text, -1 if there is none
+func (f *boyerMoore) next(text []byte) int {
+	i := len(f.pattern) - 1 // text position compared with the last pattern byte
+	for i < len(text) {
+		j := len(f.pattern) - 1
+		for j >= 0 && f.fold(text[i]) == f.pattern[j] {
+			i--
+			j--
+		}
+		if j < 0 {
+			return i + 1
+		}
+
+		i += max(f.badCharSkip[text[i]], f.goodSuffixSkip[j])
+	}
+
+	return -1
+}
+
+func (f *boyerMoore) fold(b byte) byte {
+	if f.foldCase && b >= 'A' && b <= 'Z' {
+		return b - 'A' + 'a'
+	}
+
+	return b
+}
+
+// commonSuffixLength is the length of the longest common suffix of a and b
+func commonSuffixLength(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[len(a)-1-i] == b[len(b)-1-i] {
+		i++
+	}
+
+	return i
+}
+
+// asciiLower lowercases ASCII letters only
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r - 'A' + 'a'
+		}
+		return r
+	}, s)
+}
+
+// literalSearcher finds the leftmost occurrence of any of several fixed strings:
+// Boyer-Moore for a single one, Aho-Corasick for more
+type literalSearcher struct {
+	bm        *boyerMoore
+	ac        *ahoCorasick
+	longest   bool // of several literals found at the same position the longest wins, else the first
+	wholeWord bool
+	wholeLine bool
+	unicode   bool // the word characters around a WholeWord occurrence are runes, see localeClasses
+}
+
+// newLiteralSearcher returns nil when the literals can't be searched for byte by byte,
+// that is when FoldCase needs Unicode folding
+func newLiteralSearcher(literals []string, flags Flags) *literalSearcher {
+	foldCase := flags&FoldCase != 0
+	if foldCase && flags&UTF8 != 0 && !isASCIIFold(strings.Join(literals, "")) {
+		return nil
+	}
+
+	s := &literalSearcher{
+		longest:   flags&Longest != 0,
+		wholeWord: flags&WholeWord != 0,
+		wholeLine: flags&WholeLine != 0,
+		unicode:   flags&UTF8 != 0 && flags&Perl == 0,
+	}
+	if len(literals) == 1 {
+		s.bm = newBoyerMoore(literals[0], foldCase)
+	} else {
+		s.ac = newAhoCorasick(literals, foldCase)
+	}
+
+	return s
+}
+
+// isASCIIFold reports whether every case of every letter in s is ASCII
+func isASCIIFold(s string) bool {
+	for _, r := range s {
+		if r >= utf8.RuneSelf {
+			return false
+		}
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f >= utf8.RuneSelf {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// find returns the slots of the leftmost match at or after pos and the index of the literal that matched,
+// nil and -1 if there is none
+func (s *literalSearcher) find(input []byte, pos int) (slots []int, literal int) {
+	if s.wholeLine && pos > 0 {
+		return nil, -1
+	}
+
+	var start, end int
+	if s.bm != nil {
+		start, end, literal = s.findOne(input, pos), 0, 0
+		end = start + len(s.bm.pattern)
+	} else {
+		start, end, literal = s.ac.find(input, pos, s.longest, s.fits(input))
+	}
+
+	if start < 0 {
+		return nil, -1
+	}
+	return []int{start, end}, literal
+}
+
+// findOne returns the start of the leftmost occurrence of the Boyer-Moore literal at or after pos
+// that also satisfies WholeWord and WholeLine, -1 if there is none
+func (s *literalSearcher) findOne(input []byte, pos int) int {
+	f := s.bm
+	if s.wholeLine {
+		// The only candidate is the whole input
+		if len(f.pattern) != len(input) || f.next(input) != 0 {
+			return -1
+		}
+		return 0
+	}
+
+	fits := s.fits(input)
+	for pos <= len(input) {
+		offset := f.next(input[pos:])
+		if offset < 0 {
+			return -1
+		}
+
+		start := pos + offset
+		if fits(start, start+len(f.pattern)) {
+			return start
+		}
+		pos = start + 1
+	}
+
+	return -1
+}
+
+// fits returns the WholeWord and WholeLine check of an occurrence in input
+func (s *literalSearcher) fits(input []byte) func(start int, end int) bool {
+	return func(start int, end int) bool {
+		switch {
+		case s.wholeLine:
+			return start == 0 && end == len(input)
+		case s.wholeWord:
+			return isWholeWord(input, start, end, s.unicode)
+		default:
+			return true
+		}
+	}
+}
+
+// isWholeWord reports whether input[start:end] has no word bytes right before or after it, like grep -w.
+// With unicode it looks at the runes there instead.
+func isWholeWord(input []byte, start int, end int, unicode bool) bool {
+	if unicode {
+		return runeClassBefore(input, start) != classWord && runeClassAt(input, end) != classWord
+	}
+
+	return classAt(input, start-1) != classWord && classAt(input, end) != classWord
+}
+
+// parseLiteral builds the NFA of one literal of a Literal pattern:
+// every byte (or rune in UTF-8 mode) is a literal atom
+func (p *NFAParser) parseLiteral(literal string) *NFA {
+	nfa := p.buildEmptyNFA()
+	for len(literal) > 0 {
+		r, size := rune(literal[0]), 1
+		if p.utf8 {
+			r, size = utf8.DecodeRuneInString(literal)
+		}
+
+		if !p.utf8 || r == utf8.RuneError && size <= 1 {
+			// A byte, or invalid UTF-8 which can only match itself
+			nfa = nfa.Concatenate(p.buildLiteralNFA(literal[0]))
+		} else {
+			nfa = nfa.Concatenate(p.buildRuneNFA(r))
+		}
+		literal = literal[size:]
+	}
+
+	p.pos = len(p.pattern)
+	return nfa
+}
+
+// literals returns the fixed strings the patterns are made of:
+// the patterns themselves when they are Literal, or the alternatives of EREs that are nothing but
+// alternations of literals (error|warn|fatal). ok is false for any other ERE.
+func (p *NFAParser) literals() (literals []string, ok bool) {
+	if p.literal {
+		return slices.Clone(p.patterns), true
+	}
+
+	for _, pattern := range p.patterns {
+		alternatives, ok := ereLiterals(pattern)
+		if !ok {
+			return nil, false
+		}
+		literals = append(literals, alternatives...)
+	}
+
+	return literals, len(literals) > 0
+}
+
+// ereLiterals returns the alternatives of an ERE that is an alternation of literals.
+// Escaped punctuation (\.) is literal too, an empty alternative is not: its meaning in ERE is unspecified.
+func ereLiterals(pattern string) (literals []string, ok bool) {
+	var literal []byte
+	for i := 0; i < len(pattern); i++ {
+		b := pattern[i]
+		switch {
+		case b == '|':
+			if len(literal) == 0 {
+				return nil, false
+			}
+			literals = append(literals, string(literal))
+			literal = nil
+
+		case b == '\\':
+			// \. \* \| ... but not \d, \b, \1, \< or \p{...}
+			if i+1 == len(pattern) || !isEscapedLiteral(pattern[i+1]) {
+				return nil, false
+			}
+			i++
+			literal = append(literal, pattern[i])
+
+		case strings.IndexByte(`.[]()*+?{}^$`, b) >= 0:
+			return nil, false
+
+		default:
+			literal = append(literal, b)
+		}
+	}
+
+	if len(literal) == 0 {
+		return nil, false
+	}
+	return append(literals, string(literal)), true
+}
+
+// isEscapedLiteral reports whether \b is just b: ASCII punctuation other than the word assertions \< and \>
+func isEscapedLiteral(b byte) bool {
+	return b < utf8.RuneSelf && classOfByte(b) != classWord && b != '<' && b != '>'
+}
@@ -0,0 +1,32 @@
+package nfa
+
+import "testing"
+
+func TestBoyerMoore(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		foldCase bool
+		text     string
+		want     int
+	}{
+		{"abc", false, "xxabcxx", 2},
+		{"abc", false, "abc", 0},
+		{"abc", false, "ab", -1},
+		{"abc", false, "abdabc", 3},
+		{"aab", false, "aaab", 1},
+		{"abab", false, "abacabab", 4},
+		{"a", false, "bbba", 3},
+		{"abc", true, "xABcx", 1},
+		{"ABC", true, "xabcx", 1},
+		{"abc", false, "xABcx", -1},
+		{"", false, "abc", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" in "+tt.text, func(t *testing.T) {
+			if got := newBoyerMoore(tt.pattern, tt.foldCase).next([]byte(tt.text)); got != tt.want {
+				t.Errorf("next(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
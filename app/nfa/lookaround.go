@@ -0,0 +1,165 @@
+package nfa
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lookaround assertions (Perl mode only)
+//
+// A lookaround is a zero-width assertion whose condition is a whole regular expression:
+//
+//	(?=re)   re matches starting here        (?!re)   it doesn't
+//	(?<=re)  re matches ending here          (?<!re)  it doesn't
+//
+// The body is compiled into its own automaton, the sub-automaton, and the outer NFA only
+// gets an ε-transition guarded by a LookMatcher. Whenever an engine wants to follow it,
+// the sub-automaton is run on the input around the current position:
+//
+//	q₀ --(?=\d)--> q₁        at pos: does \d match input[pos:...]?
+//
+// A lookbehind runs its body forwards from every possible start before the position,
+// so the body must have a bounded length: (?<=ab|c) tries input[pos-2:pos] and input[pos-1:pos],
+// (?<=a+) is rejected.
+//
+// Groups inside a lookaround are numbered like any other group, but the sub-automaton
+// doesn't report its captures, so they are never set in the outer match.
+// The lazy DFA can't precompute a condition on the rest of the input, patterns with
+// lookarounds are matched by the Pike VM (or NFA.Run when they also have backreferences).
+
+// LookMatcher is an ε-transition guarded by a lookaround assertion
+type LookMatcher struct {
+	Name    string // the syntax, (?=, (?!, (?<= or (?<!
+	Behind  bool   // lookbehind, the body must match ending at the position
+	Negated bool   // (?! and (?<!, the body must not match
+	MaxLen  int    // lookbehind only, the longest input the body can match
+	vm      *pikeVM
+}
+
+func (m *LookMatcher) Match(input []byte, ex *ExecutionContext) bool {
+	return m.holds(input, ex.Pos)
+}
+
+func (m *LookMatcher) IsEpsilon() bool {
+	return true
+}
+
+// holds runs the sub-automaton at pos
+func (m *LookMatcher) holds(input []byte, pos int) bool {
+	matched := false
+	if m.Behind {
+		for length := 0; length <= min(m.MaxLen, pos) && !matched; length++ {
+			matched = m.vm.matchAt(input, pos-length, pos)
+		}
+	} else {
+		matched = m.vm.matchAt(input, pos, -1)
+	}
+
+	return matched != m.Negated
+}
+
+// parseLookaround parses the body of a lookaround after its name ((?= or (?<! ...)
+// into a sub-automaton. It has its own parser so its state IDs start at 0,
+// group numbers and names carry on from the outer pattern.
+func (p *NFAParser) parseLookaround(name string) (*NFA, error) {
+	if !p.perl {
+		return nil, fmt.Errorf("lookaround assertion %s...) is only supported in Perl mode", name)
+	}
+
+	sub := &NFAParser{
+		pattern:     p.pattern,
+		pos:         p.pos,
+		depth:       1,
+		nextGroupID: p.nextGroupID,
+		groupOffset: p.groupOffset,
+		foldCase:    p.foldCase,
+		utf8:        p.utf8,
+		perl:        p.perl,
+		groupNames:  p.groupNames,
+	}
+
+	body, err := sub.parseAlternation()
+	if err != nil {
+		return nil, err
+	}
+	if sub.peek() != ')' {
+		return nil, fmt.Errorf("missing closing ')' after %s", name)
+	}
+	p.pos = sub.pos + 1 // consume ')'
+	p.nextGroupID = sub.nextGroupID
+	p.groupNames = sub.groupNames
+
+	body.NumStates = sub.numStates
+	body.NumGroups = sub.nextGroupID - 1
+
+	prog := compileProg(body)
+	if prog == nil {
+		return nil, fmt.Errorf("backreferences inside lookaround assertion %s...) are not supported", name)
+	}
+
+	matcher := &LookMatcher{
+		Name:    name,
+		Behind:  strings.HasPrefix(name, "(?<"),
+		Negated: strings.HasSuffix(name, "!"),
+		vm:      newPikeVM(prog, true, true),
+	}
+
+	if matcher.Behind {
+		maxLen, bounded := body.maxLength()
+		if !bounded {
+			return nil, fmt.Errorf("lookbehind assertion %s...) must have a bounded length", name)
+		}
+		matcher.MaxLen = maxLen
+	}
+
+	q0 := p.NewState() // Start state
+	q1 := p.NewState() // Accept state
+	q1.IsAccept = true
+
+	q0.AddTransition(q1, matcher)
+
+	return &NFA{
+		Start:  q0,
+		Accept: q1,
+	}, nil
+}
+
+// maxLength returns the length in bytes of the longest input the NFA can match,
+// bounded is false when a loop makes it unlimited
+func (nfa *NFA) maxLength() (length int, bounded bool) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	status := make([]int, nfa.NumStates)
+	longest := make([]int, nfa.NumStates) // longest path from the state to Accept
+	bounded = true
+
+	var visit func(state *State)
+	visit = func(state *State) {
+		switch status[state.ID] {
+		case visiting:
+			bounded = false // a cycle
+			return
+		case done:
+			return
+		}
+		status[state.ID] = visiting
+
+		for _, transition := range state.Transitions {
+			visit(transition.Target)
+
+			step := 0
+			if !transition.Matcher.IsEpsilon() {
+				step = 1 // every consuming matcher left is a ByteMatcher, compileProg checked
+			}
+			longest[state.ID] = max(longest[state.ID], step+longest[transition.Target.ID])
+		}
+
+		status[state.ID] = done
+	}
+	visit(nfa.Start)
+
+	return longest[nfa.Start.ID], bounded
+}
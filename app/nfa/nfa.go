@@ -5,21 +5,59 @@ import (
 	"maps"
 	"slices"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 func isDigit(char byte) bool {
 	return char >= '0' && char <= '9'
 }
 
+// swapCase returns the other case of an ASCII letter, ok is false for any other byte
+func swapCase(char byte) (other byte, ok bool) {
+	switch {
+	case char >= 'a' && char <= 'z':
+		return char - 'a' + 'A', true
+	case char >= 'A' && char <= 'Z':
+		return char - 'A' + 'a', true
+	default:
+		return char, false
+	}
+}
+
+// equalFold compares two strings ignoring ASCII case
+func equalFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := 0; i < len(a); i++ {
+		other, _ := swapCase(a[i])
+		if a[i] != b[i] && other != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Matcher defines the interface for matching input symbols
 type Matcher interface {
 	Match(input []byte, ex *ExecutionContext) bool
 	IsEpsilon() bool
 }
 
+// ByteMatcher is implemented by matchers that consume exactly one byte and only look at that byte.
+// These are the only transitions the DFA knows how to precompute.
+type ByteMatcher interface {
+	Matcher
+	MatchByte(b byte) bool
+}
+
 // LiteralMatcher matches a single literal character
 type LiteralMatcher struct {
-	Symbol byte
+	Symbol   byte
+	FoldCase bool // also match the other case of Symbol, only set for letters
 }
 
 func (m LiteralMatcher) Match(input []byte, ex *ExecutionContext) bool {
@@ -27,7 +65,16 @@ func (m LiteralMatcher) Match(input []byte, ex *ExecutionContext) bool {
 		return false
 	}
 
-	return m.Symbol == input[ex.Pos]
+	return m.MatchByte(input[ex.Pos])
+}
+
+func (m LiteralMatcher) MatchByte(b byte) bool {
+	if m.FoldCase {
+		other, _ := swapCase(m.Symbol)
+		return m.Symbol == b || other == b
+	}
+
+	return m.Symbol == b
 }
 
 func (m LiteralMatcher) IsEpsilon() bool {
@@ -59,7 +106,7 @@ func (m CaptureEpsilonMatcher) IsEpsilon() bool {
 
 type CharClassMatcher struct {
 	Name    string
-	Chars   []byte
+	Ranges  []ByteRange // sorted and merged, see normalizeRanges
 	Negated bool
 }
 
@@ -68,7 +115,11 @@ func (m CharClassMatcher) Match(input []byte, ex *ExecutionContext) bool {
 		return false
 	}
 
-	found := slices.Contains(m.Chars, input[ex.Pos])
+	return m.MatchByte(input[ex.Pos])
+}
+
+func (m CharClassMatcher) MatchByte(b byte) bool {
+	found := containsByte(m.Ranges, b)
 
 	if found != m.Negated { // XOR logic
 		return true
@@ -87,7 +138,11 @@ func (m DotMatcher) Match(input []byte, ex *ExecutionContext) bool {
 		return false
 	}
 
-	return input[ex.Pos] != '\n'
+	return m.MatchByte(input[ex.Pos])
+}
+
+func (m DotMatcher) MatchByte(b byte) bool {
+	return b != '\n'
 }
 
 func (m DotMatcher) IsEpsilon() bool {
@@ -95,7 +150,9 @@ func (m DotMatcher) IsEpsilon() bool {
 }
 
 type BackRefMatcher struct {
-	GroupID int
+	GroupID  int
+	FoldCase bool // (?i)(a)\1 matches "aA"
+	Unicode  bool // fold with Unicode rules instead of ASCII only (UTF-8 mode)
 }
 
 func (m BackRefMatcher) Match(input []byte, ex *ExecutionContext) bool {
@@ -108,34 +165,6 @@ func (m BackRefMatcher) IsEpsilon() bool {
 	return false
 }
 
-var LoopID = -1
-
-// It is essentially a stateful epsilon matcher
-type RangeQuantifierMatcher struct {
-	// If min = max, exact match
-	// If max = -1, atleast min
-	// else [min, max]
-	Min    int
-	Max    int
-	LoopID int // Unique ID to identify this quantifier
-}
-
-func (m RangeQuantifierMatcher) Match(input []byte, ex *ExecutionContext) bool {
-	curr := ex.RangeQuantifierCounter[m.LoopID]
-
-	if m.Max == -1 {
-		// Only min check (for exit transition)
-		return curr >= m.Min
-	}
-
-	// Range check (for loop-back transition)
-	return curr >= m.Min && curr <= m.Max
-}
-
-func (m RangeQuantifierMatcher) IsEpsilon() bool {
-	return true
-}
-
 // CaptureTag represents entering or exiting a capture group
 type CaptureTag struct {
 	GroupID int
@@ -155,17 +184,18 @@ type State struct {
 	Transitions []Transition
 }
 
-// Global state counter for unique IDs
-var stateCounter = -1
-
-func NewState() *State {
-	stateCounter++
-
-	return &State{
-		ID:          stateCounter,
+// NewState allocates a state with the next free ID of this parser.
+// IDs are only unique within one NFA, so parsers never share mutable state
+// and patterns can be compiled concurrently.
+func (p *NFAParser) NewState() *State {
+	state := &State{
+		ID:          p.numStates,
 		IsAccept:    false,
 		Transitions: make([]Transition, 0),
 	}
+	p.numStates++
+
+	return state
 }
 
 // AddTransition adds a labeled transition to another state
@@ -190,6 +220,21 @@ type MatchResult struct {
 	CaptureGroups map[int]CaptureGroup // GroupID -> CaptureGroup
 }
 
+// slots flattens the capture groups into [start, end) pairs like the Pike VM, -1 for unset groups
+func (r *MatchResult) slots(numGroups int) []int {
+	slots := make([]int, 2*(numGroups+1))
+	for i := range slots {
+		slots[i] = -1
+	}
+
+	for id, group := range r.CaptureGroups {
+		slots[2*id] = group.Start
+		slots[2*id+1] = group.End
+	}
+
+	return slots
+}
+
 type ActiveCapture struct {
 	GroupID int
 	Start   int
@@ -197,26 +242,47 @@ type ActiveCapture struct {
 
 // RuntimeState represents the current execution state during NFA simulation
 type ExecutionContext struct {
-	State                  *State
-	Pos                    int // Current position in input
-	ActiveCaptures         []ActiveCapture
-	CompletedGroups        map[int]CaptureGroup
-	RangeQuantifierCounter map[int]int
+	State           *State
+	Pos             int // Current position in input
+	ActiveCaptures  []ActiveCapture
+	CompletedGroups map[int]CaptureGroup
+}
+
+// key identifies the context by everything that decides how it can go on:
+// its state, its position and the captures of the groups in backRefs.
+// Other captures only change the reported submatches, the first context to get there wins.
+func (ex *ExecutionContext) key(backRefs map[int]bool) string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(ex.State.ID))
+	b.WriteByte('@')
+	b.WriteString(strconv.Itoa(ex.Pos))
+
+	for _, capture := range ex.ActiveCaptures {
+		if backRefs[capture.GroupID] {
+			fmt.Fprintf(&b, " (%d:%d", capture.GroupID, capture.Start)
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(ex.CompletedGroups)) {
+		if backRefs[id] {
+			group := ex.CompletedGroups[id]
+			fmt.Fprintf(&b, " %d:%d-%d", id, group.Start, group.End)
+		}
+	}
+
+	return b.String()
 }
 
 // Clone creates a deep copy of the execution context
 func (ex *ExecutionContext) Clone() *ExecutionContext {
 	clone := &ExecutionContext{
-		State:                  ex.State,
-		Pos:                    ex.Pos,
-		ActiveCaptures:         make([]ActiveCapture, len(ex.ActiveCaptures)),
-		CompletedGroups:        make(map[int]CaptureGroup),
-		RangeQuantifierCounter: make(map[int]int),
+		State:           ex.State,
+		Pos:             ex.Pos,
+		ActiveCaptures:  make([]ActiveCapture, len(ex.ActiveCaptures)),
+		CompletedGroups: make(map[int]CaptureGroup),
 	}
 
 	copy(clone.ActiveCaptures, ex.ActiveCaptures)
 	maps.Copy(clone.CompletedGroups, ex.CompletedGroups)
-	maps.Copy(clone.RangeQuantifierCounter, ex.RangeQuantifierCounter)
 
 	return clone
 }
@@ -225,21 +291,108 @@ func (ex *ExecutionContext) Clone() *ExecutionContext {
 type NFA struct {
 	Start  *State
 	Accept *State
+
+	// Only set on the NFA returned by ParseNFA, state IDs are in [0, NumStates)
+	NumStates  int
+	NumGroups  int          // capture groups, not counting group 0
+	GroupNames []string     // GroupNames[i] is the name of group i, "" for group 0 and unnamed groups
+	HasLazy    bool         // has non-greedy quantifiers, only leftmost-first semantics can tell them apart
+	BackRefs   map[int]bool // groups a backreference refers to, see epsilonClosure
 }
 
-// NFAParser parses regex patterns directly to NFA using Thompson construction
-type NFAParser struct {
-	pattern     string
-	pos         int
-	nextGroupID int // Start at 1 (0 is reserved for full match)
+// Upper bound on NFA size, counted repetitions like (a{1000}){1000} would otherwise exhaust memory
+const maxStates = 1 << 20
+
+// walk visits every state reachable from Start exactly once
+func (nfa *NFA) walk(visit func(state *State)) {
+	visited := make([]bool, nfa.NumStates)
+	stack := []*State{nfa.Start}
+	visited[nfa.Start.ID] = true
+
+	for len(stack) > 0 {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		visit(state)
+
+		for _, transition := range state.Transitions {
+			if !visited[transition.Target.ID] {
+				visited[transition.Target.ID] = true
+				stack = append(stack, transition.Target)
+			}
+		}
+	}
+}
+
+// canBeEmpty reports whether the fragment can match the empty string: its Accept is reachable
+// from Start through ε-transitions, assertions included, or backreferences, which can be empty too
+func (nfa *NFA) canBeEmpty() bool {
+	visited := map[*State]bool{nfa.Start: true}
+	stack := []*State{nfa.Start}
+
+	for len(stack) > 0 {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if state == nfa.Accept {
+			return true
+		}
+
+		for _, transition := range state.Transitions {
+			_, isBackRef := transition.Matcher.(BackRefMatcher)
+			if (transition.Matcher.IsEpsilon() || isBackRef) && !visited[transition.Target] {
+				visited[transition.Target] = true
+				stack = append(stack, transition.Target)
+			}
+		}
+	}
+
+	return false
 }
 
-// NewNFAParser creates a new parser for the given pattern
-func NewNFAParser(pattern string) *NFAParser {
+// isRegular reports whether every consuming transition is a ByteMatcher.
+// Backreferences are the exception: they consume a variable number of bytes that depends on captures,
+// which neither the DFA nor the Pike VM can express.
+func (nfa *NFA) isRegular() bool {
+	regular := true
+	nfa.walk(func(state *State) {
+		for _, transition := range state.Transitions {
+			if _, ok := transition.Matcher.(ByteMatcher); !ok && !transition.Matcher.IsEpsilon() {
+				regular = false
+			}
+		}
+	})
+
+	return regular
+}
+
+// NFAParser parses regex patterns directly to NFA using Thompson construction
+type NFAParser struct {
+	patterns      []string // the alternatives, each one is parsed on its own, see ParseNFA
+	pattern       string   // the one being parsed
+	pos           int
+	depth         int          // number of groups open at pos, a ')' at depth 0 closes nothing
+	nextGroupID   int          // Start at 1 (0 is reserved for full match)
+	groupOffset   int          // groups of the previous patterns, \1 of a pattern is group groupOffset+1
+	maxBackRef    int          // the highest group a backreference refers to
+	backRefs      map[int]bool // every group a backreference refers to
+	numStates     int          // IDs handed out by NewState
+	foldCase      bool         // (?i) is in effect, set by FoldCase or an inline flag group
+	utf8          bool         // the pattern and the input are UTF-8, atoms match whole runes
+	localeClasses bool         // \w, \s, [:alpha:]... and the word assertions follow Unicode, see localeClasses
+	hasLazy       bool         // a non-greedy quantifier was parsed
+	perl          bool         // Perl-only syntax is allowed (lookarounds)
+	literal       bool         // each pattern is a fixed string, see parseLiteral
+	wholeWord     bool         // matches can't have word bytes on either side (grep -w)
+	wholeLine     bool         // matches must span the whole input (grep -x)
+	groupNames    []string     // indexed by group ID, "" for unnamed groups
+}
+
+// NewNFAParser creates a new parser for the given patterns, the NFA matches any of them
+func NewNFAParser(patterns ...string) *NFAParser {
 	return &NFAParser{
-		pattern:     pattern,
+		patterns:    patterns,
 		pos:         0,
 		nextGroupID: 1,
+		groupNames:  []string{""}, // group 0
 	}
 }
 
@@ -296,12 +449,76 @@ func (p *NFAParser) readNumber() int {
 // | 7 | Anchoring                         | ^ $                  |
 // | 8 | Alternation                       | |                    |
 // +---+-----------------------------------+----------------------+
+//
+// Several patterns (grep -e a -e b) are alternatives, but each one is parsed on its own
+// rather than joined with '|': its groups are numbered from 1 for its backreferences,
+// its inline flags and parentheses end with it.
+//
+//	-e '(a)\1' -e '(b)\1'   groups 1 and 2, the second \1 refers to group 2
+//	-e 'a(' -e ')b'         an error, not the valid a()b
+//
+// The empty pattern matches the empty string, so every input: grep -e "" selects every line.
+// No pattern at all matches nothing.
 func (p *NFAParser) ParseNFA() (*NFA, error) {
-	if len(p.pattern) == 0 {
-		return nil, fmt.Errorf("empty pattern")
+	foldCase := p.foldCase
+
+	var nfa *NFA
+	for _, pattern := range p.patterns {
+		p.pattern, p.pos, p.depth = pattern, 0, 0
+		p.foldCase = foldCase
+		p.groupOffset = p.nextGroupID - 1
+
+		alternative, err := p.parsePattern()
+		if err != nil {
+			return nil, err
+		}
+
+		if nfa == nil {
+			nfa = alternative
+		} else {
+			nfa = p.Alternate(nfa, alternative)
+		}
+	}
+
+	if nfa == nil {
+		// Accept can't be reached
+		nfa = &NFA{Start: p.NewState(), Accept: p.NewState()}
+		nfa.Accept.IsAccept = true
+	}
+
+	// grep -x and -w: the whole pattern between two assertions, like ^(?:re)$
+	switch {
+	case p.wholeLine:
+		nfa = p.buildAssertNFA(AssertBeginText).Concatenate(nfa).Concatenate(p.buildAssertNFA(AssertEndText))
+	case p.wholeWord:
+		nfa = p.buildAssertNFA(AssertNotWordBefore).Concatenate(nfa).Concatenate(p.buildAssertNFA(AssertNotWordAfter))
 	}
 
-	return p.parseAlternation()
+	nfa.NumStates = p.numStates
+	nfa.NumGroups = p.nextGroupID - 1
+	nfa.GroupNames = p.groupNames
+	nfa.HasLazy = p.hasLazy
+	nfa.BackRefs = p.backRefs
+	return nfa, nil
+}
+
+// parsePattern parses p.pattern, one of the patterns
+func (p *NFAParser) parsePattern() (*NFA, error) {
+	if p.literal {
+		return p.parseLiteral(p.pattern), nil
+	}
+
+	nfa, err := p.parseAlternation()
+	if err != nil {
+		return nil, err
+	}
+
+	// A backreference to a group the pattern doesn't have
+	if p.maxBackRef >= p.nextGroupID {
+		return nil, fmt.Errorf("invalid backreference \\%d", p.maxBackRef-p.groupOffset)
+	}
+
+	return nfa, nil
 }
 
 func (p *NFAParser) parseAlternation() (*NFA, error) {
@@ -320,14 +537,18 @@ func (p *NFAParser) parseAlternation() (*NFA, error) {
 			return nil, err
 		}
 
-		left = left.Alternate(right)
+		left = p.Alternate(left, right)
 	}
 
 	return left, nil
 }
 
-// parseSequence handles sequences of atoms
+// parseSequence handles sequences of atoms, an empty one (a| or ()) matches the empty string
 func (p *NFAParser) parseSequence() (*NFA, error) {
+	if p.atSequenceEnd() {
+		return p.buildEmptyNFA(), nil
+	}
+
 	// Parse left atom
 	left, err := p.parseQuantifiedAtom()
 	if err != nil {
@@ -335,8 +556,7 @@ func (p *NFAParser) parseSequence() (*NFA, error) {
 	}
 
 	// Parse remaining atoms and concatenate
-	// Stop if we hit characters '|', ')' that belong to higher-level constructs
-	for !p.isEOF() && p.peek() != '|' && p.peek() != ')' {
+	for !p.atSequenceEnd() {
 		right, err := p.parseQuantifiedAtom()
 		if err != nil {
 			return nil, err
@@ -348,6 +568,12 @@ func (p *NFAParser) parseSequence() (*NFA, error) {
 	return left, nil
 }
 
+// atSequenceEnd reports whether the sequence ends at pos: at the end of the pattern, before a '|'
+// or before the ')' of the group it's in. Outside of groups ')' is an atom, see parseAtom.
+func (p *NFAParser) atSequenceEnd() bool {
+	return p.isEOF() || p.peek() == '|' || p.peek() == ')' && p.depth > 0
+}
+
 func isQuantifier(ch byte) bool {
 	return ch == '*' || ch == '+' || ch == '?' || ch == '{'
 }
@@ -365,39 +591,40 @@ func (p *NFAParser) parseQuantifiedAtom() (*NFA, error) {
 		return atom, nil
 	}
 
-	// Quantifiers are by default greedy
-	// Appending a ? makes it lazy/non-greedy *?, +?, ??, {n,m}?
-	ch := p.advance()
-	// greedy := true
-	// if p.peek() == '?' {
-	// 	p.advance() // consume '?'
-	// 	greedy = false
-	// }
-
-	switch ch {
+	var minCount, maxCount int
+	switch p.advance() {
 	case '*':
-		return p.buildRangeQuantifier(atom, 0, -1), nil
-		// return p.buildKleeneStar(atom), nil
+		minCount, maxCount = 0, -1
 
 	case '+':
-		return p.buildRangeQuantifier(atom, 1, -1), nil
-		// return p.buildKleenePlus(atom), nil
+		minCount, maxCount = 1, -1
 
 	case '?':
-		return p.buildRangeQuantifier(atom, 0, 1), nil
-		// return p.buildOptional(atom), nil
+		minCount, maxCount = 0, 1
 
 	case '{':
-		return p.parseQuantifierGroup(atom /* , startPos */)
+		minCount, maxCount, err = p.parseQuantifierGroup()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	default:
-		return atom, nil
+	// Quantifiers are by default greedy
+	// Appending a ? makes it lazy/non-greedy *?, +?, ??, {n,m}?
+	greedy := true
+	if p.peek() == '?' {
+		p.advance() // consume '?'
+		greedy = false
+		p.hasLazy = true
 	}
+
+	return p.buildRangeQuantifier(atom, minCount, maxCount, greedy)
 }
 
-func (p *NFAParser) parseQuantifierGroup(atom *NFA /* , startPos int */) (*NFA, error) {
-	var minCount = p.readNumber()
-	maxCount := minCount
+// parseQuantifierGroup parses the bounds of {m}, {m,} or {m,n} after the '{'
+func (p *NFAParser) parseQuantifierGroup() (minCount int, maxCount int, err error) {
+	minCount = p.readNumber()
+	maxCount = minCount
 
 	if !p.isEOF() && p.peek() == ',' {
 		p.advance()
@@ -406,20 +633,20 @@ func (p *NFAParser) parseQuantifierGroup(atom *NFA /* , startPos int */) (*NFA,
 			maxCount = p.readNumber()
 
 			if maxCount < minCount {
-				return nil, fmt.Errorf("invalid range quantifier {%d,%d}, n must be >= m", minCount, maxCount)
+				return 0, 0, fmt.Errorf("invalid range quantifier {%d,%d}, n must be >= m", minCount, maxCount)
 			}
 		}
 	}
 
 	if p.peek() != '}' {
-		return nil, fmt.Errorf("expected '}', found: %c", p.peek())
+		return 0, 0, fmt.Errorf("expected '}', found: %c", p.peek())
 	}
 	if p.isEOF() {
-		return nil, fmt.Errorf("unexpected EOF")
+		return 0, 0, fmt.Errorf("unexpected EOF")
 	}
 	p.advance() // consume '}'
 
-	return p.buildRangeQuantifier(atom /* , startPos */, minCount, maxCount), nil
+	return minCount, maxCount, nil
 }
 
 //  q₀, q₁, q₂, q₃, q₄
@@ -430,9 +657,9 @@ func (p *NFAParser) parseQuantifierGroup(atom *NFA /* , startPos int */) (*NFA,
 //		┌──ε──▶ ( N1 ) ─ε──▶┐
 //	    q₀              	q₁
 //		└──ε──▶ ( N2 ) ─ε──▶┘
-func (nfa1 *NFA) Alternate(nfa2 *NFA) *NFA {
-	q0 := NewState() // Start state q0
-	q1 := NewState() // Accept state q1
+func (p *NFAParser) Alternate(nfa1 *NFA, nfa2 *NFA) *NFA {
+	q0 := p.NewState() // Start state q0
+	q1 := p.NewState() // Accept state q1
 	q1.IsAccept = true
 
 	// ε-transitions from q0 (new start) to both alts
@@ -470,22 +697,31 @@ func (nfa1 *NFA) Alternate(nfa2 *NFA) *NFA {
 // q1 --'a'--> q2 (original atom transition)
 // q2 --ε--> q1 (loop back to match another 'a')
 // q2 --ε--> q3 (exit after matching some 'a's)
-func (p *NFAParser) buildKleeneStar(nfa *NFA) *NFA {
-	q0 := NewState() // Start state q0
-	q3 := NewState() // Accept state q3
+//
+// When the atom can't match the empty string, q2 loops back to q0 instead, like Go's regexp,
+// so q0 is the only decision point. An iteration that comes back to q0 without consuming
+// anything then finds it already visited at that position and ends there: (\w*?x*|\s+)* on
+// "xaA xa" matches "xa", the third iteration would start in the middle of the lazy \w*? of the
+// second. The two shapes differ for lazy quantifiers only.
+func (p *NFAParser) buildKleeneStar(nfa *NFA, greedy bool) *NFA {
+	q0 := p.NewState() // Start state q0
+	q3 := p.NewState() // Accept state q3
 	q3.IsAccept = true
 
 	// q0 --ε--> q1 (enter the 'a' pattern)
-	q0.AddTransition(nfa.Start, EpsilonMatcher{})
-
 	// q0 --ε--> q3 (skip 'a' entirely - 0 occurrences)
-	q0.AddTransition(q3, EpsilonMatcher{})
+	addChoice(q0, nfa.Start, q3, greedy)
 
-	// q2 --ε--> q1 (loop back to match another 'a')
-	nfa.Accept.AddTransition(nfa.Start, EpsilonMatcher{})
+	if !nfa.canBeEmpty() {
+		// q2 --ε--> q0 (decide again)
+		nfa.Accept.AddTransition(q0, EpsilonMatcher{})
+		nfa.Accept.IsAccept = false
+		return &NFA{Start: q0, Accept: q3}
+	}
 
+	// q2 --ε--> q1 (loop back to match another 'a')
 	// q2 --ε--> q3 (exit after matching some 'a's)
-	nfa.Accept.AddTransition(q3, EpsilonMatcher{})
+	addChoice(nfa.Accept, nfa.Start, q3, greedy)
 
 	nfa.Accept.IsAccept = false
 	return &NFA{Start: q0, Accept: q3}
@@ -508,19 +744,17 @@ func (p *NFAParser) buildKleeneStar(nfa *NFA) *NFA {
 // q1 --'a'--> q2 (original atom transition)
 // q2 --ε--> q1 (loop back to match another 'a')
 // q2 --ε--> q3 (exit after matching some 'a's)
-func (p *NFAParser) buildKleenePlus(atom *NFA) *NFA {
-	q0 := NewState() // Start state q0
-	q3 := NewState() // Accept state q3
+func (p *NFAParser) buildKleenePlus(atom *NFA, greedy bool) *NFA {
+	q0 := p.NewState() // Start state q0
+	q3 := p.NewState() // Accept state q3
 	q3.IsAccept = true
 
 	// q0 --ε--> q1 (enter the 'a' pattern)
 	q0.AddTransition(atom.Start, EpsilonMatcher{})
 
 	// q2 --ε--> q1 (loop back to match another 'a')
-	atom.Accept.AddTransition(atom.Start, EpsilonMatcher{})
-
 	// q2 --ε--> q3 (exit after matching some 'a's)
-	atom.Accept.AddTransition(q3, EpsilonMatcher{})
+	addChoice(atom.Accept, atom.Start, q3, greedy)
 
 	atom.Accept.IsAccept = false
 	return &NFA{Start: q0, Accept: q3}
@@ -538,21 +772,19 @@ func (p *NFAParser) buildKleenePlus(atom *NFA) *NFA {
 // q2: Original atom's accept state (no longer accepting)
 // q3: New final accept state
 
-// Transitions:
-// q0 --ε--> q3 (skip 'a' entirely - zero occurrences)
+// Transitions (in priority order, entering first makes it greedy, skipping first lazy):
 // q0 --ε--> q1 (enter the 'a' pattern)
+// q0 --ε--> q3 (skip 'a' entirely - zero occurrences)
 // q1 --'a'--> q2 (original atom transition)
 // q2 --ε--> q3 (exit after matching some 'a's)
-func (p *NFAParser) buildOptional(atom *NFA) *NFA {
-	q0 := NewState() // Start state q0
-	q3 := NewState() // Accept state q3
+func (p *NFAParser) buildOptional(atom *NFA, greedy bool) *NFA {
+	q0 := p.NewState() // Start state q0
+	q3 := p.NewState() // Accept state q3
 	q3.IsAccept = true
 
-	// q0 --ε--> q3 (skip 'a' entirely - zero occurrences)
-	q0.AddTransition(q3, EpsilonMatcher{})
-
 	// q0 --ε--> q1 (enter the 'a' pattern)
-	q0.AddTransition(atom.Start, EpsilonMatcher{})
+	// q0 --ε--> q3 (skip 'a' entirely - zero occurrences)
+	addChoice(q0, atom.Start, q3, greedy)
 
 	// q2 --ε--> q3 (exit after matching some 'a's)
 	atom.Accept.AddTransition(q3, EpsilonMatcher{})
@@ -561,74 +793,126 @@ func (p *NFAParser) buildOptional(atom *NFA) *NFA {
 	return &NFA{Start: q0, Accept: q3}
 }
 
-//					  ┌───ε{m,n}──┐
-//					  ▼			  │
-// Pattern: q₀ --ε--> q₁--(atom)->q₂ --ε{m,n}-> q₃
-
-func (p *NFAParser) buildRangeQuantifier(atom *NFA /* , startPos int */, minCount int, maxCount int) *NFA {
-	// Repeatedly parsing the same atom doesn't make sense.
-	// It is better to tag these loops and keep their count in execution context
-
-	// var nfa *NFA
-	// endPos := p.pos
-	// for range minCount {
-	// 	p.pos = startPos
-	// 	if nfa == nil {
-	// 		nfa = atom
-	// 	} else {
-	// 		nextAtom, _ := p.parseAtom()
-	// 		nfa = nfa.Concatenate(nextAtom)
-	// 	}
-	// }
-
-	// if maxCount == -1 {
-	// 	p.pos = startPos
-	// 	nextAtom, _ := p.parseAtom()
-	// 	kleenStar := p.buildKleeneStar(nextAtom)
-	// 	nfa = nfa.Concatenate(kleenStar)
-	// } else {
-	// 	for range maxCount - minCount {
-	// 		p.pos = startPos
-	// 		nextAtom, _ := p.parseAtom()
-	// 		optional := p.buildOptional(nextAtom)
-	// 		nfa = nfa.Concatenate(optional)
-	// 	}
-	// }
-
-	// p.pos = endPos
-
-	q0 := NewState() // Start state q0
-	q3 := NewState() // Accept state q3
-	q3.IsAccept = true
+// addChoice adds ε-transitions from a quantifier's decision point to both targets.
+// Transition order is priority order: greedy tries one more iteration first, lazy tries to stop first.
+func addChoice(from *State, more *State, done *State, greedy bool) {
+	if greedy {
+		from.AddTransition(more, EpsilonMatcher{})
+		from.AddTransition(done, EpsilonMatcher{})
+	} else {
+		from.AddTransition(done, EpsilonMatcher{})
+		from.AddTransition(more, EpsilonMatcher{})
+	}
+}
 
-	// q0 --ε--> q1 (enter the 'a' pattern)
-	q0.AddTransition(atom.Start, EpsilonMatcher{})
+// Counted repetition is expanded into independent copies of the atom (like GNU grep's dfa.c)
+//
+//	a{2,4} = a a (a (a)?)?
+//	a{2,}  = a a+
+//	a{0}   = ε
+//
+// A loop counter kept in the execution context would make every (state, count) pair
+// a distinct configuration, so neither a DFA nor a Pike VM could treat the NFA
+// as a plain set of states. Copies keep every engine working on Thompson fragments.
+func (p *NFAParser) buildRangeQuantifier(atom *NFA, minCount int, maxCount int, greedy bool) (*NFA, error) {
+	switch {
+	case minCount == 0 && maxCount == -1:
+		return p.buildKleeneStar(atom, greedy), nil
+
+	case minCount == 1 && maxCount == -1:
+		return p.buildKleenePlus(atom, greedy), nil
 
-	if minCount == 0 {
-		q0.AddTransition(q3, EpsilonMatcher{})
+	case minCount == 0 && maxCount == 1:
+		return p.buildOptional(atom, greedy), nil
+
+	case maxCount == 0:
+		return p.buildEmptyNFA(), nil
 	}
 
-	// Don't need counters when max = 1
-	if maxCount == 1 {
-		atom.Accept.AddTransition(q3, EpsilonMatcher{})
-		atom.Accept.IsAccept = false
-		return &NFA{Start: q0, Accept: q3}
+	// Clone before building, the builders below rewire the atom's accept state
+	numCopies := max(minCount, maxCount)
+	copies := []*NFA{atom}
+	for len(copies) < numCopies {
+		copies = append(copies, p.cloneFragment(atom))
+
+		if p.numStates > maxStates {
+			return nil, fmt.Errorf("pattern too large: repetition expands to more than %d states", maxStates)
+		}
 	}
 
-	// {m,n} uses two transitions sharing the same counter:
-	// 1. Loop-back: Match another atom while count < max
-	// 2. Exit: Finish when count >= min
-	// {m,n} = {0,n-1} -> {m, -1}
-	LoopID++
-	loopCount := max(-1, maxCount-1)
-	// Loop-back: q2 --ε{0,n-1}-> q1 (loop back to match < max 'a')
-	atom.Accept.AddTransition(atom.Start, RangeQuantifierMatcher{0, loopCount, LoopID})
+	// Optional tail (a (a)?)? is built inside out, nesting keeps it greedy (or lazy)
+	var tail *NFA
+	if maxCount == -1 {
+		tail = p.buildKleenePlus(copies[minCount-1], greedy)
+		copies = copies[:minCount-1]
+	} else {
+		for i := maxCount - 1; i >= minCount; i-- {
+			if tail == nil {
+				tail = p.buildOptional(copies[i], greedy)
+			} else {
+				tail = p.buildOptional(copies[i].Concatenate(tail), greedy)
+			}
+		}
+		copies = copies[:minCount]
+	}
 
-	// Exit: q2 --ε{m,-1}-> q3 (can exit if match >= min 'a')
-	atom.Accept.AddTransition(q3, RangeQuantifierMatcher{minCount, -1, LoopID})
+	var nfa *NFA
+	for _, c := range copies {
+		if nfa == nil {
+			nfa = c
+		} else {
+			nfa = nfa.Concatenate(c)
+		}
+	}
 
-	atom.Accept.IsAccept = false
-	return &NFA{Start: q0, Accept: q3}
+	switch {
+	case nfa == nil:
+		return tail, nil
+	case tail == nil:
+		return nfa, nil
+	default:
+		return nfa.Concatenate(tail), nil
+	}
+}
+
+// cloneFragment copies every state reachable from nfa.Start into fresh states.
+// A fragment is self-contained until it is wired into its parent, so this copies exactly the atom.
+func (p *NFAParser) cloneFragment(nfa *NFA) *NFA {
+	clones := make(map[*State]*State)
+	stack := []*State{nfa.Start}
+	clones[nfa.Start] = p.NewState()
+
+	for len(stack) > 0 {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		clone := clones[state]
+		clone.IsAccept = state.IsAccept
+
+		for _, transition := range state.Transitions {
+			target, ok := clones[transition.Target]
+			if !ok {
+				target = p.NewState()
+				clones[transition.Target] = target
+				stack = append(stack, transition.Target)
+			}
+
+			clone.AddTransition(target, transition.Matcher)
+		}
+	}
+
+	return &NFA{Start: clones[nfa.Start], Accept: clones[nfa.Accept]}
+}
+
+// buildEmptyNFA matches the empty string: q₀ --ε-→ q₁ (accept)
+func (p *NFAParser) buildEmptyNFA() *NFA {
+	q0 := p.NewState()
+	q1 := p.NewState()
+	q1.IsAccept = true
+
+	q0.AddTransition(q1, EpsilonMatcher{})
+
+	return &NFA{Start: q0, Accept: q1}
 }
 
 // Concatenate combines two NFAs
@@ -666,23 +950,149 @@ func (p *NFAParser) parseAtom() (*NFA, error) {
 	case '[':
 		return p.parseCharClass()
 
+	case '^':
+		return p.buildAssertNFA(AssertBeginText), nil
+
+	case '$':
+		return p.buildAssertNFA(AssertEndText), nil
+
 	case '.':
+		if p.utf8 {
+			// Any rune but newline, a multi-byte character is consumed whole
+			return p.buildRuneClassNFA(".", []RuneRange{{'\n', '\n'}}, true), nil
+		}
 		return p.buildDotNFA(), nil
 
 	case '(':
 		return p.parseGroup()
 
+	case ')':
+		// Closes no group, GNU ERE takes it literally and PCRE rejects it
+		if p.perl {
+			return nil, fmt.Errorf("unmatched closing parenthesis")
+		}
+		return p.buildLiteralNFA(symbol), nil
+
 	default:
+		if p.utf8 {
+			return p.parseLiteralRune(symbol)
+		}
 		return p.buildLiteralNFA(symbol), nil
 	}
 }
 
+// parseLiteralRune builds the literal starting with symbol, the rest of its bytes are consumed too
+func (p *NFAParser) parseLiteralRune(symbol byte) (*NFA, error) {
+	r := rune(symbol)
+	if symbol >= utf8.RuneSelf {
+		var err error
+		if r, err = p.parseRune(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.buildRuneNFA(r), nil
+}
+
+// parseGroup parses a group after its '(':
+//
+//	(re)                numbered capture
+//	(?P<name>re)        named capture, also numbered, (?<name>re) is the same
+//	(?P=name)           backreference to a named group, like \k<name>
+//	(?:re) (?i:re)...   non-capturing, see parseFlagGroup
+//	(?=re) (?<!re)...   lookarounds, Perl mode only, see lookaround.go
+//	(?>re)              atomic, only the AST backtracking engine can commit to a choice
 func (p *NFAParser) parseGroup() (*NFA, error) {
+	if p.peek() != '?' {
+		return p.parseCaptureGroup("")
+	}
+	p.advance() // consume '?'
+
+	rest := p.pattern[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, "="), strings.HasPrefix(rest, "!"):
+		p.pos++
+		return p.parseLookaround(p.pattern[p.pos-3 : p.pos])
+
+	case strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, "<!"):
+		p.pos += 2
+		return p.parseLookaround(p.pattern[p.pos-4 : p.pos])
+
+	case strings.HasPrefix(rest, "P<"):
+		p.pos += 2
+		return p.parseNamedGroup()
+
+	case strings.HasPrefix(rest, "<"):
+		p.pos++
+		return p.parseNamedGroup()
+
+	case strings.HasPrefix(rest, "P="):
+		p.pos += 2
+		name, err := p.parseGroupName(')')
+		if err != nil {
+			return nil, err
+		}
+
+		return p.buildNamedBackReference(name)
+
+	case strings.HasPrefix(rest, ">"):
+		return nil, fmt.Errorf("atomic groups (?>...) are not supported by the automaton engines")
+
+	default:
+		return p.parseFlagGroup()
+	}
+}
+
+// parseNamedGroup parses a named group after its '<'
+func (p *NFAParser) parseNamedGroup() (*NFA, error) {
+	name, err := p.parseGroupName('>')
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(p.groupNames[p.groupOffset+1:], name) {
+		return nil, fmt.Errorf("duplicate capture group name: %s", name)
+	}
+
+	return p.parseCaptureGroup(name)
+}
+
+// parseGroupName reads a group name up to and including the closing delimiter.
+// Names are made of word characters and don't start with a digit, so they never look like group numbers.
+func (p *NFAParser) parseGroupName(closing byte) (string, error) {
+	end := strings.IndexByte(p.pattern[p.pos:], closing)
+	if end < 0 {
+		return "", fmt.Errorf("missing closing '%c' after group name", closing)
+	}
+
+	name := p.pattern[p.pos : p.pos+end]
+	if name == "" || isDigit(name[0]) {
+		return "", fmt.Errorf("invalid group name: %q", name)
+	}
+	for i := 0; i < len(name); i++ {
+		if classOfByte(name[i]) != classWord {
+			return "", fmt.Errorf("invalid group name: %q", name)
+		}
+	}
+
+	p.pos += end + 1
+	return name, nil
+}
+
+// parseCaptureGroup parses the group's content and wraps it in capture tags,
+// the group ID is allocated before the content so groups are numbered by their '('
+func (p *NFAParser) parseCaptureGroup(name string) (*NFA, error) {
 	currGroupID := p.nextGroupID
 	p.nextGroupID++ // Ready for next group
+	p.groupNames = append(p.groupNames, name)
+
+	// Inline flags set inside the group end with it
+	foldCase := p.foldCase
+	defer func() { p.foldCase = foldCase }()
 
 	// Parse content inside parentheses
+	p.depth++
 	nfa, err := p.parseAlternation()
+	p.depth--
 	if err != nil {
 		return nil, err
 	}
@@ -696,8 +1106,8 @@ func (p *NFAParser) parseGroup() (*NFA, error) {
 	p.advance() // consume ')'
 
 	// Now build: q0 --'('-→ NFA --')'-→ q1
-	q0 := NewState()
-	q1 := NewState()
+	q0 := p.NewState()
+	q1 := p.NewState()
 	q1.IsAccept = nfa.Accept.IsAccept
 
 	startTag := CaptureTag{GroupID: currGroupID, IsStart: true}
@@ -710,9 +1120,68 @@ func (p *NFAParser) parseGroup() (*NFA, error) {
 	return &NFA{Start: q0, Accept: q1}, nil
 }
 
+// parseFlagGroup parses inline flags after "(?", the only flag so far is i (case-insensitive)
+//
+//	(?i)      until the end of the enclosing group
+//	(?-i)     turns it off again
+//	(?i:re)   only inside re, which is not captured
+//	(?:re)    no flags, just a group that is not captured
+func (p *NFAParser) parseFlagGroup() (*NFA, error) {
+	foldCase := p.foldCase
+	negate := false
+
+	for {
+		if p.isEOF() {
+			return nil, fmt.Errorf("missing closing ')'")
+		}
+
+		switch ch := p.advance(); ch {
+		case 'i':
+			foldCase = !negate
+
+		case '-':
+			if negate {
+				return nil, fmt.Errorf("invalid flag group: double negation")
+			}
+			negate = true
+
+		case ')':
+			// Only changes the flags, matches the empty string
+			if isQuantifier(p.peek()) {
+				return nil, fmt.Errorf("missing argument to repetition operator")
+			}
+
+			p.foldCase = foldCase
+			return p.buildEmptyNFA(), nil
+
+		case ':':
+			outer := p.foldCase
+			p.foldCase = foldCase
+
+			p.depth++
+			nfa, err := p.parseAlternation()
+			p.depth--
+			if err != nil {
+				return nil, err
+			}
+
+			if p.peek() != ')' {
+				return nil, fmt.Errorf("missing closing ')'")
+			}
+			p.advance() // consume ')'
+
+			p.foldCase = outer
+			return nfa, nil
+
+		default:
+			return nil, fmt.Errorf("unknown flag in group: %c", ch)
+		}
+	}
+}
+
 func (p *NFAParser) buildDotNFA() *NFA {
-	q0 := NewState()
-	q1 := NewState()
+	q0 := p.NewState()
+	q1 := p.NewState()
 	q1.IsAccept = true
 
 	q0.AddTransition(q1, DotMatcher{})
@@ -723,19 +1192,58 @@ func (p *NFAParser) buildDotNFA() *NFA {
 func (p *NFAParser) parseEscape() (*NFA, error) {
 	var nfa *NFA
 	symbol := p.advance()
+	// \d = [0-9], \w = [a-zA-Z0-9_], \s = [ \t\n\r\f\v] and \D \W \S their negations
+	if ranges, negated, ok := p.localeShorthand(symbol); ok {
+		return p.buildRuneClassNFA("\\"+string(symbol), ranges, negated), nil
+	}
+	if ranges, negated, ok := shorthandRanges(symbol); ok {
+		if negated && p.utf8 {
+			return p.buildRuneClassNFA("\\"+string(symbol), toRuneRanges(ranges), true), nil
+		}
+		return p.buildCharClassNFA("\\"+string(symbol), ranges, negated), nil
+	}
+
 	switch {
-	case symbol == 'd':
-		// \d = [0-9]
-		nfa = p.buildCharClassNFA("\\d", []byte("0123456789"), false)
+	case symbol == 'b':
+		nfa = p.buildAssertNFA(AssertWordBoundary)
+
+	case symbol == 'B':
+		nfa = p.buildAssertNFA(AssertNotWordBoundary)
+
+	case symbol == '<':
+		nfa = p.buildAssertNFA(AssertWordStart)
+
+	case symbol == '>':
+		nfa = p.buildAssertNFA(AssertWordEnd)
+
+	case symbol == 'A':
+		nfa = p.buildAssertNFA(AssertBeginText)
+
+	case symbol == 'z':
+		nfa = p.buildAssertNFA(AssertEndText)
+
+	case symbol == 'k':
+		// \k<name>
+		if p.peek() != '<' {
+			return nil, fmt.Errorf("expected '<' after \\k")
+		}
+		p.advance() // consume '<'
+
+		name, err := p.parseGroupName('>')
+		if err != nil {
+			return nil, err
+		}
 
-	case symbol == 'w':
-		// \w = [a-zA-Z0-9_]
-		wordChars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_"
-		nfa = p.buildCharClassNFA("\\w", []byte(wordChars), false)
+		return p.buildNamedBackReference(name)
+
+	case symbol == 'p' || symbol == 'P':
+		start := p.pos - 2
+		ranges, negated, err := p.parseUnicodeClass()
+		if err != nil {
+			return nil, err
+		}
 
-	case symbol == 's':
-		// \s = [ \t\n\r\f\v] (whitespace)
-		nfa = p.buildCharClassNFA("\\s", []byte(" \t\n\r\f\v"), false)
+		nfa = p.buildRuneClassNFA(p.pattern[start:p.pos], ranges, negated != (symbol == 'P'))
 
 	case isDigit(symbol):
 		groupID, err := p.parseBackreferenceGroupID(symbol)
@@ -743,8 +1251,14 @@ func (p *NFAParser) parseEscape() (*NFA, error) {
 			return nil, err
 		}
 
+		// Numbered within the pattern, checked once all its groups are known
+		groupID += p.groupOffset
+		p.maxBackRef = max(p.maxBackRef, groupID)
 		nfa = p.buildBackReference(groupID)
 
+	case p.utf8:
+		return p.parseLiteralRune(symbol)
+
 	default:
 		nfa = p.buildLiteralNFA(symbol)
 	}
@@ -771,13 +1285,28 @@ func (p *NFAParser) parseBackreferenceGroupID(digit byte) (int, error) {
 	return groupID, nil
 }
 
+// buildNamedBackReference refers to a named group of the pattern by its number, the group must come first
+func (p *NFAParser) buildNamedBackReference(name string) (*NFA, error) {
+	i := slices.Index(p.groupNames[p.groupOffset+1:], name)
+	if i < 0 {
+		return nil, fmt.Errorf("backreference to undefined group name: %s", name)
+	}
+
+	return p.buildBackReference(p.groupOffset + 1 + i), nil
+}
+
 func (p *NFAParser) buildBackReference(groupID int) *NFA {
-	q0 := NewState() // Start state
-	q1 := NewState() // Accept state
+	if p.backRefs == nil {
+		p.backRefs = make(map[int]bool)
+	}
+	p.backRefs[groupID] = true
+
+	q0 := p.NewState() // Start state
+	q1 := p.NewState() // Accept state
 	q1.IsAccept = true
 
 	// Create backreference matcher with integer group ID
-	matcher := BackRefMatcher{GroupID: groupID}
+	matcher := BackRefMatcher{GroupID: groupID, FoldCase: p.foldCase, Unicode: p.utf8}
 	q0.AddTransition(q1, matcher)
 
 	return &NFA{
@@ -786,35 +1315,198 @@ func (p *NFAParser) buildBackReference(groupID int) *NFA {
 	}
 }
 
+// parseCharClass parses a bracket expression after its '['
+//
+//	[abc]  [^abc]   listed bytes, or every byte except those (runes in UTF-8 mode)
+//	[a-z0-9]        ranges, both ends included
+//	[]a]  [^]a]     ']' right after '[' or '[^' is a literal
+//	[a-]  [-a]      so is '-' at either end
+//	[\d_]  [^\]]    \d \w \s \D \W \S and escaped bytes
+//	[[:alpha:]_]    POSIX classes, see posixClasses
+//	[[=a=]]         equivalence class, in the C locale only a itself
+//	[[.hyphen.]]    collating symbol, a single byte or one of collatingNames
+//	[\p{Greek}]     Unicode classes, UTF-8 mode only
 func (p *NFAParser) parseCharClass() (*NFA, error) {
+	start := p.pos - 1 // the '['
+
 	negated := false
 	if p.peek() == '^' {
 		p.advance()
 		negated = true
 	}
 
-	var chars []byte
-	for !p.isEOF() && p.peek() != ']' {
-		char := p.advance()
-		chars = append(chars, char)
+	var ranges []RuneRange
+	first := true
+
+	for first || p.peek() != ']' {
+		if p.isEOF() {
+			return nil, fmt.Errorf("expecting ']'")
+		}
+
+		lo, shorthand, err := p.parseClassChar()
+		if err != nil {
+			return nil, err
+		}
+		first = false
+
+		if shorthand != nil {
+			if p.peek() == '-' && p.pos+1 < len(p.pattern) && p.pattern[p.pos+1] != ']' {
+				return nil, fmt.Errorf("invalid range in %s", p.pattern[start:p.pos+1])
+			}
+			ranges = append(ranges, shorthand...)
+			continue
+		}
+
+		// a-b, unless the '-' is the last byte of the class
+		hi := lo
+		if p.peek() == '-' && p.pos+1 < len(p.pattern) && p.pattern[p.pos+1] != ']' {
+			p.advance() // consume '-'
+
+			hi, shorthand, err = p.parseClassChar()
+			if err != nil {
+				return nil, err
+			}
+			if shorthand != nil || hi < lo {
+				return nil, fmt.Errorf("invalid range end in %s", p.pattern[start:p.pos])
+			}
+		}
+
+		ranges = append(ranges, RuneRange{lo, hi})
+	}
+	p.advance() // consume ']'
+
+	if p.utf8 {
+		return p.buildRuneClassNFA(p.pattern[start:p.pos], ranges, negated), nil
 	}
 
+	// Without UTF-8 every member is a single byte
+	return p.buildCharClassNFA(p.pattern[start:p.pos], toByteRanges(ranges), negated), nil
+}
+
+// parseClassChar reads one member of a bracket expression,
+// shorthand is set instead of char for the members that can't be a range end: \d, [:digit:], [=a=]...
+func (p *NFAParser) parseClassChar() (char rune, shorthand []RuneRange, err error) {
 	if p.isEOF() {
-		return nil, fmt.Errorf("expecting ']'")
+		return 0, nil, fmt.Errorf("expecting ']'")
 	}
-	p.advance() // consume ']'
 
-	name := fmt.Sprintf("[%s]", chars)
+	symbol := p.advance()
+	if symbol == '[' && (p.peek() == ':' || p.peek() == '=' || p.peek() == '.') {
+		return p.parseBracketSymbol()
+	}
+
+	if symbol == '\\' {
+		if p.isEOF() {
+			return 0, nil, fmt.Errorf("trailing backslash in bracket expression")
+		}
+		symbol = p.advance()
+
+		if ranges, negated, ok := p.localeShorthand(symbol); ok {
+			if negated {
+				ranges = negateRuneRanges(ranges)
+			}
+			return 0, ranges, nil
+		}
+		if ranges, negated, ok := shorthandRanges(symbol); ok {
+			return 0, p.classRanges(ranges, negated), nil
+		}
 
-	return p.buildCharClassNFA(name, chars, negated), nil
+		if symbol == 'p' || symbol == 'P' {
+			ranges, negated, err := p.parseUnicodeClass()
+			if err != nil {
+				return 0, nil, err
+			}
+			if negated != (symbol == 'P') {
+				ranges = negateRuneRanges(ranges)
+			}
+			return 0, ranges, nil
+		}
+	}
+
+	if p.utf8 && symbol >= utf8.RuneSelf {
+		char, err = p.parseRune()
+		return char, nil, err
+	}
+
+	return rune(symbol), nil, nil
+}
+
+// classRanges widens an ASCII class (\w, [:alpha:]) for a bracket expression,
+// negated means every byte but those, or every rune but those in UTF-8 mode
+func (p *NFAParser) classRanges(ranges []ByteRange, negated bool) []RuneRange {
+	if !negated {
+		return toRuneRanges(ranges)
+	}
+	if p.utf8 {
+		return negateRuneRanges(toRuneRanges(ranges))
+	}
+
+	return toRuneRanges(negateRanges(ranges))
 }
 
-func (p *NFAParser) buildCharClassNFA(name string, chars []byte, negated bool) *NFA {
-	q0 := NewState() // Start state
-	q1 := NewState() // Accept state
+// parseBracketSymbol parses [:name:], [=x=] or [.x.] inside a bracket expression, after the inner '['
+func (p *NFAParser) parseBracketSymbol() (char rune, shorthand []RuneRange, err error) {
+	delim := p.advance() // ':', '=' or '.'
+
+	end := strings.Index(p.pattern[p.pos:], string(delim)+"]")
+	if end < 0 {
+		return 0, nil, fmt.Errorf("unterminated [%c in bracket expression", delim)
+	}
+	name := p.pattern[p.pos : p.pos+end]
+	p.pos += end + 2
+
+	// A single character, a whole rune in UTF-8 mode
+	single := len(name) == 1
+	if p.utf8 && utf8.RuneCountInString(name) == 1 {
+		char, _ = utf8.DecodeRuneInString(name)
+		single = true
+	} else if single {
+		char = rune(name[0])
+	}
+
+	switch delim {
+	case ':':
+		ranges, ok := posixClasses[name]
+		if !ok {
+			return 0, nil, fmt.Errorf("invalid character class [:%s:]", name)
+		}
+		if p.localeClasses {
+			return 0, localeClasses()[name], nil
+		}
+		return 0, toRuneRanges(ranges), nil
+
+	case '=':
+		// Every character is its own equivalence class in the C locale
+		if !single {
+			return 0, nil, fmt.Errorf("invalid equivalence class [=%s=]", name)
+		}
+		return 0, []RuneRange{{char, char}}, nil
+
+	default:
+		if single {
+			return char, nil, nil
+		}
+
+		b, ok := collatingNames[name]
+		if !ok {
+			return 0, nil, fmt.Errorf("invalid collating element [.%s.]", name)
+		}
+		return rune(b), nil, nil
+	}
+}
+
+func (p *NFAParser) buildCharClassNFA(name string, ranges []ByteRange, negated bool) *NFA {
+	q0 := p.NewState() // Start state
+	q1 := p.NewState() // Accept state
 	q1.IsAccept = true
 
-	matcher := CharClassMatcher{Name: name, Chars: chars, Negated: negated}
+	ranges = normalizeRanges(slices.Clone(ranges))
+	if p.foldCase {
+		// Before negation, so [^a] rejects A too
+		ranges = foldRanges(ranges)
+	}
+
+	matcher := CharClassMatcher{Name: name, Ranges: ranges, Negated: negated}
 	q0.AddTransition(q1, matcher)
 
 	return &NFA{
@@ -828,12 +1520,13 @@ func (p *NFAParser) buildCharClassNFA(name string, chars []byte, negated bool) *
 //
 // Structure: q₀ --symbol-→ q₁ (accept)
 func (p *NFAParser) buildLiteralNFA(symbol byte) *NFA {
-	q0 := NewState() // Start state
-	q1 := NewState() // Accept state
+	q0 := p.NewState() // Start state
+	q1 := p.NewState() // Accept state
 	q1.IsAccept = true
 
 	// Add transition: δ(q₀, symbol) = {q₁}
-	matcher := LiteralMatcher{Symbol: symbol}
+	_, isLetter := swapCase(symbol)
+	matcher := LiteralMatcher{Symbol: symbol, FoldCase: p.foldCase && isLetter}
 	q0.AddTransition(q1, matcher)
 
 	return &NFA{
@@ -861,7 +1554,17 @@ func deltaFunction(contexts []*ExecutionContext, input []byte) []*ExecutionConte
 					continue
 				}
 
-				if group.Text == string(input[ctx.Pos:endPos]) {
+				text := string(input[ctx.Pos:endPos])
+				equal := group.Text == text
+				if !equal && matcher.FoldCase {
+					if matcher.Unicode {
+						equal = strings.EqualFold(group.Text, text)
+					} else {
+						equal = equalFold(group.Text, text)
+					}
+				}
+
+				if equal {
 					newCtx := ctx.Clone()
 					newCtx.State = transition.Target
 					newCtx.Pos += len(group.Text)
@@ -893,10 +1596,19 @@ func deltaFunction(contexts []*ExecutionContext, input []byte) []*ExecutionConte
 
 // epsilonClosure computes ε-closure of a set of states
 // ε-closure(S) = set of states reachable from S using only ε-transitions
-func epsilonClosure(contexts []*ExecutionContext, input []byte) []*ExecutionContext {
+//
+// Contexts are told apart by their key (state, position and backreferenced captures), not by their
+// state alone: backreferences make those captures part of what is left to match, in (ab|a)b+\1 the
+// contexts with group 1 "ab" and "a" both have to go on. visited is shared by all the steps of a Run,
+// so a context that comes back unchanged, like after the empty \1 of (a*)\1*, is dropped
+// instead of looping forever.
+func epsilonClosure(contexts []*ExecutionContext, input []byte, backRefs map[int]bool, visited map[string]bool) []*ExecutionContext {
 	closure := make([]*ExecutionContext, 0)
-	visited := make(map[*State]bool)
+
+	// The stack pops from the end, push in reverse so contexts and transitions
+	// are explored in priority order (same order as the Pike VM)
 	stack := slices.Clone(contexts)
+	slices.Reverse(stack)
 
 	// Use DFS to follow all ε-paths
 	for len(stack) > 0 {
@@ -904,32 +1616,25 @@ func epsilonClosure(contexts []*ExecutionContext, input []byte) []*ExecutionCont
 		current := stack[len(stack)-1]
 		stack = stack[:len(stack)-1]
 
-		if visited[current.State] {
+		key := current.key(backRefs)
+		if visited[key] {
 			continue
 		}
 
-		visited[current.State] = true
+		visited[key] = true
 		closure = append(closure, current)
 
 		// Follow all ε-transitions
-		for _, transition := range current.State.Transitions {
-			// Handle RangeQuantifierMatcher
-			if matcher, ok := transition.Matcher.(RangeQuantifierMatcher); ok {
-				oldCount := current.RangeQuantifierCounter[matcher.LoopID]
-				newCount := oldCount + 1
-
-				newCtx := current.Clone()
-				newCtx.RangeQuantifierCounter[matcher.LoopID] = newCount
-
-				// Check if transition is allowed
-				if matcher.Match(input, newCtx) && !visited[transition.Target] {
-					newCtx.State = transition.Target
-					stack = append(stack, newCtx)
+		for _, transition := range slices.Backward(current.State.Transitions) {
+			if transition.Matcher.IsEpsilon() {
+				// Assertions are only followed where they hold
+				switch matcher := transition.Matcher.(type) {
+				case AssertMatcher, *LookMatcher:
+					if !matcher.Match(input, current) {
+						continue
+					}
 				}
-				continue
-			}
 
-			if transition.Matcher.IsEpsilon() && !visited[transition.Target] {
 				newCtx := current.Clone()
 				newCtx.State = transition.Target
 
@@ -940,7 +1645,6 @@ func epsilonClosure(contexts []*ExecutionContext, input []byte) []*ExecutionCont
 
 				stack = append(stack, newCtx)
 			}
-
 		}
 	}
 
@@ -979,89 +1683,72 @@ func (ex *ExecutionContext) ApplyTags(tags []CaptureTag, input []byte) {
 	}
 }
 
-// RunNFA executes the NFA against the input
-func (nfa *NFA) Run(input []byte, pos int, hasEndAnchor bool) *MatchResult {
+// Run executes the NFA against the input, looking for a match that starts exactly at pos.
+// Contexts stay in priority order (see epsilonClosure), so when one of them accepts:
+//   - longest (POSIX): keep going, a context that accepts further in the input is longer
+//   - leftmost-first (Perl): contexts behind it have lower priority and are dropped,
+//     the ones ahead of it keep running and replace the match if they accept later
+func (nfa *NFA) Run(input []byte, pos int, longest bool) *MatchResult {
+	result := &MatchResult{Matched: false}
+
 	currContexts := []*ExecutionContext{
 		{
-			State:                  nfa.Start,
-			Pos:                    pos,
-			ActiveCaptures:         make([]ActiveCapture, 0),
-			CompletedGroups:        make(map[int]CaptureGroup),
-			RangeQuantifierCounter: make(map[int]int),
+			State:           nfa.Start,
+			Pos:             pos,
+			ActiveCaptures:  make([]ActiveCapture, 0),
+			CompletedGroups: make(map[int]CaptureGroup),
 		},
 	}
 
 	// Apply ε-closure to initial context
-	currContexts = epsilonClosure(currContexts, input)
+	visited := make(map[string]bool)
+	currContexts = epsilonClosure(currContexts, input, nfa.BackRefs, visited)
 
 	for {
-		// For each context, try all transitions
-		currContexts = deltaFunction(currContexts, input)
-		if len(currContexts) == 0 {
-			return &MatchResult{Matched: false} // cannot proceed further
-		}
-
-		// Apply ε-closure after each transition
-		currContexts = epsilonClosure(currContexts, input)
-
 		// Check if any current state is a final state
-		for _, ctx := range currContexts {
-			if ctx.State.IsAccept {
+		// before consuming anything too, patterns like a* accept the empty string
+		for k, ctx := range currContexts {
+			if !ctx.State.IsAccept {
+				continue
+			}
+
+			if !longest || !result.Matched || ctx.Pos > result.CaptureGroups[0].End {
 				ctx.CompletedGroups[0] = CaptureGroup{
 					Start: pos,
 					End:   ctx.Pos,
 					Text:  string(input[pos:ctx.Pos]),
 				}
 
-				if hasEndAnchor {
-					if ctx.Pos == len(input) {
-						return &MatchResult{
-							Matched:       true,
-							CaptureGroups: ctx.CompletedGroups,
-						}
-					}
-				} else {
-					return &MatchResult{
-						Matched:       true,
-						CaptureGroups: ctx.CompletedGroups,
-					}
+				result = &MatchResult{
+					Matched:       true,
+					CaptureGroups: ctx.CompletedGroups,
 				}
 			}
+
+			if !longest {
+				currContexts = currContexts[:k]
+				break
+			}
 		}
-	}
-}
 
-func MatchNFA(input []byte, pattern string) (bool, error) {
-	hasStartAnchor := pattern[0] == '^'
-	hasEndAnchor := pattern[len(pattern)-1] == '$'
+		// For each context, try all transitions
+		currContexts = deltaFunction(currContexts, input)
+		if len(currContexts) == 0 {
+			return result // cannot proceed further
+		}
 
-	// Strip anchors from pattern before parsing
-	if hasStartAnchor {
-		pattern = pattern[1:]
-	}
-	if hasEndAnchor {
-		pattern = pattern[:len(pattern)-1]
+		// Apply ε-closure after each transition
+		currContexts = epsilonClosure(currContexts, input, nfa.BackRefs, visited)
 	}
+}
 
-	parser := NewNFAParser(pattern)
-	nfa, err := parser.ParseNFA()
+// MatchNFA compiles the pattern and matches it against a single input.
+// Callers matching many inputs should Compile once and reuse the Regexp instead.
+func MatchNFA(input []byte, pattern string) (bool, error) {
+	re, err := Compile(pattern)
 	if err != nil {
 		return false, err
 	}
 
-	if hasStartAnchor {
-		result := nfa.Run(input, 0, hasEndAnchor)
-		if result.Matched {
-			return true, nil
-		}
-	} else {
-		for i := range len(input) {
-			result := nfa.Run(input, i, hasEndAnchor)
-			if result.Matched {
-				return true, nil
-			}
-		}
-	}
-
-	return false, nil
+	return re.Match(input), nil
 }
@@ -0,0 +1,222 @@
+package nfa
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Backreferences only run on the ExecutionContext simulation (Run), Match and Find have to agree
+func TestBackreferences(t *testing.T) {
+	tests := []struct {
+		pattern string
+		flags   Flags
+		input   string
+		want    []int // FindSubmatchIndex
+	}{
+		{`(a)\1`, Perl, "xaa", []int{1, 3, 1, 2}},
+		{`(a|b)\1`, Perl, "abba", []int{1, 3, 1, 2}},
+		{`(\w+) \1`, Perl, "say hello hello", []int{4, 15, 4, 9}},
+		{`(a)\1`, Perl, "aba", nil},
+
+		// Contexts in the same state with different captures both go on
+		{`(ab|a)b+\1`, Perl, "abba", []int{0, 4, 0, 1}},
+		{`(a|b)+(b+)?\2`, Perl, "aabb", []int{0, 4, 1, 2, 2, 3}},
+		{`(ab|a)b+\1`, Longest, "abba", []int{0, 4, -1, -1}},
+		{`(a|b)+(b+)?\2`, Longest, "aabb", []int{0, 4, -1, -1, -1, -1}},
+
+		// An empty backreference in a loop comes back to the same context, it has to stop
+		{`(a*)\1*`, Perl, "b", []int{0, 0, 0, 0}},
+		{`(a*)\1*`, Longest, "b", []int{0, 0, -1, -1}},
+		{`(a*)\1*b`, Perl, "aab", []int{0, 3, 0, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" on "+tt.input, func(t *testing.T) {
+			re, err := CompileFlags(tt.pattern, tt.flags)
+			if err != nil {
+				t.Fatalf("CompileFlags(%q) error: %v", tt.pattern, err)
+			}
+
+			if got := re.FindSubmatchIndex([]byte(tt.input)); !equalInts(got, tt.want) {
+				t.Errorf("FindSubmatchIndex = %v, want %v", got, tt.want)
+			}
+			if got := re.MatchString(tt.input); got != (tt.want != nil) {
+				t.Errorf("MatchString = %v, want %v", got, tt.want != nil)
+			}
+		})
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	tests := []struct {
+		pattern string
+		flags   Flags
+		input   string
+		want    []int // FindIndex
+	}{
+		{`abc`, Longest | FoldCase, "xABc", []int{1, 4}},
+		{`abc`, Longest, "xABc", nil},
+		{`(?i)abc`, Longest, "xABc", []int{1, 4}},
+		{`a(?i)bc`, Perl, "aBC", []int{0, 3}},
+		{`a(?i)bc`, Perl, "ABC", nil},
+		{`(?i:b)c`, Perl, "BcBC", []int{0, 2}},
+		{`[a-c]+`, Longest | FoldCase, "xCAB", []int{1, 4}},
+		{`[^a]`, Longest | FoldCase, "Ab", []int{1, 2}},
+
+		// Backreferences fold too: the group matched "a", \1 matches "A"
+		{`(a)\1`, Longest | FoldCase, "aA", []int{0, 2}},
+		{`(a)\1`, Longest, "aA", nil},
+		{`(?i)(ab)\1`, Perl, "abAB", []int{0, 4}},
+		{`(?i)(ab)\1`, Perl, "ABab", []int{0, 4}},
+		{`(?i:(ab))\1`, Perl, "abAB", nil},
+
+		// Unicode folding in UTF-8 mode, ASCII only without it
+		{`é`, Longest | FoldCase | UTF8, "É", []int{0, 2}},
+		{`é`, Longest | FoldCase, "É", nil},
+		{`k`, Longest | FoldCase | UTF8, "\u212a", []int{0, 3}},
+		{`(é)\1`, Longest | FoldCase | UTF8, "éÉ", []int{0, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" on "+tt.input, func(t *testing.T) {
+			re, err := CompileFlags(tt.pattern, tt.flags)
+			if err != nil {
+				t.Fatalf("CompileFlags(%q) error: %v", tt.pattern, err)
+			}
+
+			if got := re.FindIndex([]byte(tt.input)); !equalInts(got, tt.want) {
+				t.Errorf("flags %d: FindIndex = %v, want %v", tt.flags, got, tt.want)
+			}
+			if got := re.MatchString(tt.input); got != (tt.want != nil) {
+				t.Errorf("flags %d: MatchString = %v, want %v", tt.flags, got, tt.want != nil)
+			}
+		})
+	}
+}
+
+func TestNamedGroups(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		names   []string
+		want    []int // FindSubmatchIndex
+	}{
+		{`(?P<y>\d+)-(?P<m>\d+)`, "on 2024-05", []string{"", "y", "m"}, []int{3, 10, 3, 7, 8, 10}},
+		{`(?<y>\d+)-(\d+)`, "2024-05", []string{"", "y", ""}, []int{0, 7, 0, 4, 5, 7}},
+		{`(?<c>a|b)\k<c>`, "abba", []string{"", "c"}, []int{1, 3, 1, 2}},
+		{`(?P<c>a|b)(?P=c)`, "abba", []string{"", "c"}, []int{1, 3, 1, 2}},
+		{`(?<c>a|b)\k<c>`, "abab", []string{"", "c"}, nil},
+		{`(?<w>\w+) \k<w>`, "the the end", []string{"", "w"}, []int{0, 7, 0, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" on "+tt.input, func(t *testing.T) {
+			re, err := CompileFlags(tt.pattern, Perl)
+			if err != nil {
+				t.Fatalf("CompileFlags(%q) error: %v", tt.pattern, err)
+			}
+
+			if got := re.SubexpNames(); !slices.Equal(got, tt.names) {
+				t.Errorf("SubexpNames = %q, want %q", got, tt.names)
+			}
+			for i, name := range tt.names {
+				if name != "" && re.SubexpIndex(name) != i {
+					t.Errorf("SubexpIndex(%q) = %d, want %d", name, re.SubexpIndex(name), i)
+				}
+			}
+			if got := re.SubexpIndex("missing"); got != -1 {
+				t.Errorf("SubexpIndex(%q) = %d, want -1", "missing", got)
+			}
+			if got := re.FindSubmatchIndex([]byte(tt.input)); !equalInts(got, tt.want) {
+				t.Errorf("FindSubmatchIndex = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamedGroupsErrors(t *testing.T) {
+	tests := []string{
+		`(?<a>x)(?<a>y)`,
+		`(?<a>x)\k<b>`,
+		`\k<a>(?<a>x)`,
+		`(?<a>x)\ka`,
+		`(?<a>x)\k<a`,
+		`(?<>x)`,
+		`(?<1a>x)`,
+		`(?P=a)`,
+		`(?>a+)b`,
+	}
+
+	for _, pattern := range tests {
+		t.Run(pattern, func(t *testing.T) {
+			if _, err := CompileFlags(pattern, Perl); err == nil {
+				t.Errorf("CompileFlags(%q) succeeded, want an error", pattern)
+			}
+		})
+	}
+}
+
+// State and loop IDs belong to each parser: compiling in parallel gives the same NFAs as
+// compiling one pattern at a time, and `go test -race` has nothing to report
+func TestConcurrentCompile(t *testing.T) {
+	patterns := []string{
+		`a{2,3}b`,
+		`(ab|cd){1,}x`,
+		`(a+)\1`,
+		`x(a|b)*?y`,
+		`[[:alpha:]]+\d{2}`,
+		`(?<w>\w+) \k<w>`,
+	}
+	const input = "aab abcdx aaaa xabby ab12 the the"
+
+	numStates := make([]int, len(patterns))
+	want := make([][][]int, len(patterns))
+	shared := make([]*Regexp, len(patterns))
+	for i, pattern := range patterns {
+		nfa, err := NewNFAParser(pattern).ParseNFA()
+		if err != nil {
+			t.Fatalf("ParseNFA(%q) error: %v", pattern, err)
+		}
+		numStates[i] = nfa.NumStates
+
+		seen := make([]bool, nfa.NumStates)
+		nfa.walk(func(state *State) {
+			if state.ID < 0 || state.ID >= nfa.NumStates || seen[state.ID] {
+				t.Errorf("%q: state ID %d is out of range or used twice", pattern, state.ID)
+				return
+			}
+			seen[state.ID] = true
+		})
+
+		shared[i] = MustCompile(pattern)
+		want[i] = shared[i].FindAllIndex([]byte(input), -1)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		for i, pattern := range patterns {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				nfa, err := NewNFAParser(pattern).ParseNFA()
+				if err != nil {
+					t.Errorf("ParseNFA(%q) error: %v", pattern, err)
+					return
+				}
+				if nfa.NumStates != numStates[i] {
+					t.Errorf("%q: NumStates = %d, want %d", pattern, nfa.NumStates, numStates[i])
+				}
+
+				// One Regexp compiled here, one shared by every goroutine
+				for _, re := range []*Regexp{MustCompile(pattern), shared[i]} {
+					if got := re.FindAllIndex([]byte(input), -1); !slices.EqualFunc(got, want[i], equalInts) {
+						t.Errorf("%q: FindAllIndex = %v, want %v", pattern, got, want[i])
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
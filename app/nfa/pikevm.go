@@ -0,0 +1,400 @@
+package nfa
+
+import "sync"
+
+// Pike VM
+//
+// The NFA graph is flattened into a program: one instruction per state, plus extra
+// instructions when a state has several transitions or a transition saves captures.
+//
+//	pattern: (a|b)c
+//	0 split 7, 8       q₀ has two ε-transitions
+//	1 byte 'a' -> 4
+//	...
+//	7 save 2 -> 1      entering group 1 stores its start position in slot 2
+//
+// All threads advance over the input in lock step. Each thread owns a fixed-size slot array
+// (start and end position of every group) instead of the cloned maps of ExecutionContext.
+// Two threads on the same instruction at the same position behave identically from then on,
+// so the one that arrived first (higher priority) is kept and the other is dropped.
+// A sparse set makes that check O(1), and bounds the threads per byte by the program size.
+
+// InstOp is the kind of a Pike VM instruction
+type InstOp uint8
+
+const (
+	InstFail   InstOp = iota // dead end, no transitions
+	InstMatch                // accepting state
+	InstByte                 // consume one byte accepted by Matcher, continue at Out
+	InstNop                  // ε-transition to Out
+	InstSplit                // ε-transitions to every Outs, in priority order
+	InstSave                 // store the current position in slot Arg, continue at Out
+	InstAssert               // continue at Out if Assert holds at the current position
+	InstLook                 // continue at Out if the lookaround in Look holds at the current position
+)
+
+// Inst is a single instruction of a Prog
+type Inst struct {
+	Op      InstOp
+	Out     int
+	Outs    []int // InstSplit only
+	Arg     int   // InstSave only
+	Matcher ByteMatcher
+	Assert  AssertMatcher // InstAssert only
+	Look    *LookMatcher  // InstLook only
+}
+
+// Prog is the flattened NFA, instruction i is state i for every i < NumStates
+type Prog struct {
+	Insts    []Inst
+	Start    int
+	NumSlots int // 2 per group, slots 0 and 1 hold the whole match
+}
+
+// compileProg flattens the NFA, it returns nil when the NFA is not regular (backreferences)
+func compileProg(nfa *NFA) *Prog {
+	if !nfa.isRegular() {
+		return nil
+	}
+
+	prog := &Prog{
+		Insts:    make([]Inst, nfa.NumStates),
+		Start:    nfa.Start.ID,
+		NumSlots: 2 * (nfa.NumGroups + 1),
+	}
+
+	nfa.walk(func(state *State) {
+		var outs []Inst
+		for _, transition := range state.Transitions {
+			outs = append(outs, prog.compileTransition(transition))
+		}
+		if state.IsAccept {
+			outs = append(outs, Inst{Op: InstMatch})
+		}
+
+		switch len(outs) {
+		case 0:
+			prog.Insts[state.ID] = Inst{Op: InstFail}
+
+		case 1:
+			prog.Insts[state.ID] = outs[0]
+
+		default:
+			split := Inst{Op: InstSplit}
+			for _, inst := range outs {
+				split.Outs = append(split.Outs, prog.emit(inst))
+			}
+			prog.Insts[state.ID] = split
+		}
+	})
+
+	return prog
+}
+
+// compileTransition returns the instruction for a transition without adding it to the program
+func (prog *Prog) compileTransition(transition Transition) Inst {
+	target := transition.Target.ID
+
+	switch matcher := transition.Matcher.(type) {
+	case ByteMatcher:
+		return Inst{Op: InstByte, Out: target, Matcher: matcher}
+
+	case CaptureEpsilonMatcher:
+		// One save per tag, chained back to front so they run in order
+		inst := Inst{Op: InstNop, Out: target}
+		for i := len(matcher.CaptureTags) - 1; i >= 0; i-- {
+			if inst.Op != InstNop {
+				target = prog.emit(inst)
+			}
+
+			tag := matcher.CaptureTags[i]
+			slot := 2 * tag.GroupID
+			if !tag.IsStart {
+				slot++
+			}
+
+			inst = Inst{Op: InstSave, Out: target, Arg: slot}
+		}
+		return inst
+
+	case AssertMatcher:
+		return Inst{Op: InstAssert, Out: target, Assert: matcher}
+
+	case *LookMatcher:
+		return Inst{Op: InstLook, Out: target, Look: matcher}
+
+	default:
+		return Inst{Op: InstNop, Out: target}
+	}
+}
+
+// emit appends an instruction and returns its index
+func (prog *Prog) emit(inst Inst) int {
+	prog.Insts = append(prog.Insts, inst)
+	return len(prog.Insts) - 1
+}
+
+// pikeVM is shared by every goroutine using the Regexp,
+// the thread lists of each search live in a pikeMachine borrowed from the pool
+type pikeVM struct {
+	prog     *Prog
+	anchored bool // matches can only start at position 0, see isAnchoredStart
+	longest  bool // leftmost-longest instead of leftmost-first
+	pool     sync.Pool
+}
+
+type pikeThread struct {
+	slots []int
+}
+
+// threadList is a sparse set of instruction indexes, in priority order.
+// Entries for ε-instructions only mark them as visited, their thread is nil.
+type threadList struct {
+	sparse []int
+	dense  []threadEntry
+}
+
+type threadEntry struct {
+	pc     int
+	thread *pikeThread
+}
+
+type pikeMachine struct {
+	vm      *pikeVM
+	clist   threadList
+	nlist   threadList
+	free    []*pikeThread
+	scratch []int
+	matched bool
+	slots   []int // slots of the best match so far
+}
+
+func newPikeVM(prog *Prog, anchored bool, longest bool) *pikeVM {
+	vm := &pikeVM{
+		prog:     prog,
+		anchored: anchored,
+		longest:  longest,
+	}
+	vm.pool.New = func() any {
+		n := len(prog.Insts)
+		return &pikeMachine{
+			vm:      vm,
+			clist:   threadList{sparse: make([]int, n), dense: make([]threadEntry, 0, n)},
+			nlist:   threadList{sparse: make([]int, n), dense: make([]threadEntry, 0, n)},
+			scratch: make([]int, prog.NumSlots),
+			slots:   make([]int, prog.NumSlots),
+		}
+	}
+
+	return vm
+}
+
+// search finds the leftmost match at or after pos in a single left to right scan
+// and returns its slots, nil means no match. Instead of restarting the simulation at every offset, a new thread
+// is seeded at each position. Seeded threads have the lowest priority, so the thread list
+// is ordered by start position first and by path priority second.
+//
+// A match doesn't end the search, threads ahead of it in the list may still find a better one:
+//   - leftmost-first: threads behind the match have lower priority and are dropped,
+//     the ones ahead keep running and replace the match if they reach Match too
+//   - leftmost-longest: only threads starting after the match are dropped,
+//     any thread starting at or before it that matches later is longer
+//
+// Of the threads matching the same span, the first one to get there wins, so leftmost-longest
+// submatches follow path priority rather than the POSIX subexpression rules.
+// Regexp doesn't report them, see Longest.
+func (vm *pikeVM) search(input []byte, pos int) []int {
+	m := vm.pool.Get().(*pikeMachine)
+	defer vm.pool.Put(m)
+
+	m.matched = false
+
+	for i := pos; i <= len(input); i++ {
+		// Keep seeding until a match is found, any later start would not be leftmost
+		if !m.matched && (i == 0 || !vm.anchored) {
+			for j := range m.scratch {
+				m.scratch[j] = -1
+			}
+			m.scratch[0] = i
+			m.add(&m.clist, vm.prog.Start, input, i, m.scratch)
+		}
+
+		if len(m.clist.dense) == 0 {
+			break
+		}
+
+		m.step(i, input)
+		m.clist, m.nlist = m.nlist, m.clist
+	}
+
+	m.clear(&m.clist)
+	m.clear(&m.nlist)
+
+	if !m.matched {
+		return nil
+	}
+
+	slots := make([]int, len(m.slots))
+	copy(slots, m.slots)
+	return slots
+}
+
+// matchAt reports whether a match starts exactly at start, and ends exactly at end unless end is -1.
+// It runs the sub-automaton of a lookaround, whose VM is built with longest:
+// then no thread is dropped on a match, one that reaches end later still counts.
+func (vm *pikeVM) matchAt(input []byte, start int, end int) bool {
+	m := vm.pool.Get().(*pikeMachine)
+	defer vm.pool.Put(m)
+
+	m.matched = false
+	for j := range m.scratch {
+		m.scratch[j] = -1
+	}
+	m.scratch[0] = start
+	m.add(&m.clist, vm.prog.Start, input, start, m.scratch)
+
+	last := len(input)
+	if end >= 0 {
+		last = end
+	}
+
+	found := false
+	for i := start; i <= last && len(m.clist.dense) > 0 && !found; i++ {
+		m.step(i, input)
+		m.clist, m.nlist = m.nlist, m.clist
+
+		// The longest match so far ends at i, if any
+		found = m.matched && (end < 0 || m.slots[1] == end)
+	}
+
+	m.clear(&m.clist)
+	m.clear(&m.nlist)
+
+	return found
+}
+
+// step runs every thread of clist on input[i], the survivors are added to nlist
+func (m *pikeMachine) step(i int, input []byte) {
+	vm := m.vm
+	cutoff := -1 // leftmost-longest: threads starting after this can't win anymore
+
+	for k, entry := range m.clist.dense {
+		t := entry.thread
+		if t == nil {
+			continue
+		}
+
+		if cutoff >= 0 && t.slots[0] > cutoff {
+			m.free = append(m.free, t)
+			continue
+		}
+
+		inst := &vm.prog.Insts[entry.pc]
+		switch inst.Op {
+		case InstMatch:
+			start := t.slots[0]
+			if vm.longest {
+				if !m.matched || start < m.slots[0] || (start == m.slots[0] && i > m.slots[1]) {
+					copy(m.slots, t.slots)
+					m.slots[1] = i
+				}
+
+				m.matched = true
+				cutoff = start
+				break
+			}
+
+			copy(m.slots, t.slots)
+			m.slots[1] = i
+			m.matched = true
+
+			// leftmost-first: every remaining thread has lower priority
+			for _, rest := range m.clist.dense[k:] {
+				if rest.thread != nil {
+					m.free = append(m.free, rest.thread)
+				}
+			}
+			m.clist.dense = m.clist.dense[:0]
+			return
+
+		case InstByte:
+			if i < len(input) && inst.Matcher.MatchByte(input[i]) {
+				m.add(&m.nlist, inst.Out, input, i+1, t.slots)
+			}
+		}
+
+		m.free = append(m.free, t)
+	}
+
+	m.clist.dense = m.clist.dense[:0]
+}
+
+// add follows ε-instructions from pc in priority order and queues a thread on every
+// instruction that consumes input or matches. slots is restored before returning.
+func (m *pikeMachine) add(list *threadList, pc int, input []byte, pos int, slots []int) {
+	if list.contains(pc) {
+		return
+	}
+
+	j := len(list.dense)
+	list.sparse[pc] = j
+	list.dense = append(list.dense, threadEntry{pc: pc})
+
+	inst := &m.vm.prog.Insts[pc]
+	switch inst.Op {
+	case InstNop:
+		m.add(list, inst.Out, input, pos, slots)
+
+	case InstSplit:
+		for _, out := range inst.Outs {
+			m.add(list, out, input, pos, slots)
+		}
+
+	case InstSave:
+		old := slots[inst.Arg]
+		slots[inst.Arg] = pos
+		m.add(list, inst.Out, input, pos, slots)
+		slots[inst.Arg] = old
+
+	case InstAssert:
+		if inst.Assert.holdsAt(input, pos) {
+			m.add(list, inst.Out, input, pos, slots)
+		}
+
+	case InstLook:
+		if inst.Look.holds(input, pos) {
+			m.add(list, inst.Out, input, pos, slots)
+		}
+
+	case InstMatch, InstByte:
+		t := m.alloc()
+		copy(t.slots, slots)
+		list.dense[j].thread = t
+	}
+}
+
+func (m *pikeMachine) alloc() *pikeThread {
+	if n := len(m.free); n > 0 {
+		t := m.free[n-1]
+		m.free = m.free[:n-1]
+		return t
+	}
+
+	return &pikeThread{slots: make([]int, m.vm.prog.NumSlots)}
+}
+
+// clear empties the list, giving its threads back to the free list
+func (m *pikeMachine) clear(list *threadList) {
+	for _, entry := range list.dense {
+		if entry.thread != nil {
+			m.free = append(m.free, entry.thread)
+		}
+	}
+
+	list.dense = list.dense[:0]
+}
+
+func (l *threadList) contains(pc int) bool {
+	i := l.sparse[pc]
+	return i < len(l.dense) && l.dense[i].pc == pc
+}
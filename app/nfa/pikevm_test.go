@@ -0,0 +1,65 @@
+package nfa
+
+import "testing"
+
+// Leftmost-longest picks the overall span only, its submatches would not be the POSIX ones and are not reported
+func TestLongestSubmatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		longest []int // FindSubmatchIndex with Longest, the groups are never reported
+		perl    []int // FindSubmatchIndex with Perl, the groups of the highest priority path
+	}{
+		{`(a|ab)(c|bcd)(d*)`, "abcd", []int{0, 4, -1, -1, -1, -1, -1, -1}, []int{0, 4, 0, 1, 1, 4, 4, 4}},
+		{`(a|ab)(c|bcd)`, "abcd", []int{0, 4, -1, -1, -1, -1}, []int{0, 4, 0, 1, 1, 4}},
+		{`(a*)(ab)*(b*)`, "ab", []int{0, 2, -1, -1, -1, -1, -1, -1}, []int{0, 2, 0, 1, -1, -1, 1, 2}},
+		{`(a|ab)`, "ab", []int{0, 2, -1, -1}, []int{0, 1, 0, 1}},
+		{`x(a|ab)*y`, "xabay", []int{0, 5, -1, -1}, []int{0, 5, 3, 4}},
+		{`(a)\1`, "aa", []int{0, 2, -1, -1}, []int{0, 2, 0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			for _, flags := range []Flags{Longest, Perl} {
+				want := tt.perl
+				if flags == Longest {
+					want = tt.longest
+				}
+
+				re, err := CompileFlags(tt.pattern, flags)
+				if err != nil {
+					t.Fatalf("CompileFlags(%q) error: %v", tt.pattern, err)
+				}
+
+				if got := re.FindSubmatchIndex([]byte(tt.input)); !equalInts(got, want) {
+					t.Errorf("flags %d: FindSubmatchIndex(%q) = %v, want %v", flags, tt.input, got, want)
+				}
+				if got := re.FindAllSubmatchIndex([]byte(tt.input), -1); len(got) != 1 || !equalInts(got[0], want) {
+					t.Errorf("flags %d: FindAllSubmatchIndex(%q) = %v, want [%v]", flags, tt.input, got, want)
+				}
+			}
+		})
+	}
+}
+
+// The Pike VM's slot arrays have to give the same captures as the ExecutionContext maps of Run
+func TestPikeVMAgreesWithRun(t *testing.T) {
+	for _, flags := range []Flags{Longest | Perl, Perl} {
+		for _, pattern := range enginePatterns {
+			re, err := CompileFlags(pattern, flags)
+			if err != nil {
+				t.Fatalf("CompileFlags(%q) error: %v", pattern, err)
+			}
+			if re.pike == nil {
+				t.Fatalf("%q: no Pike VM", pattern)
+			}
+
+			for _, input := range engineInputs {
+				want := runSlots(re, []byte(input))
+				if got := re.pike.search([]byte(input), 0); !equalInts(got, want) {
+					t.Errorf("%q on %q, longest %v: Pike VM %v, Run %v", pattern, input, flags&Longest != 0, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package nfa
+
+// Required literal prefilter
+//
+// Most patterns contain a string that every match has to contain, like GNU grep's "must" strings:
+//
+//	ERROR \d+: (\w+)    "ERROR " (also a prefix) and ": ", the longest one is used
+//	[a-z]+\.log         ".log"
+//	(foo|bar)baz        "baz"
+//
+// An input without it can't match, and a Boyer-Moore search for it skips most bytes of such an input,
+// so it's rejected before any automaton runs. When the string is also a prefix of every match,
+// no match can start before its first occurrence and Find starts searching there.
+//
+// The required strings are read off the dominator chain of Accept: the states that every path from
+// Start to Accept goes through, in the order they are passed. When such a state has a single transition,
+// a literal byte, to the next state of the chain, that byte is part of every match. Consecutive ones
+// (with only ε-transitions in between) form a string:
+//
+//	ERROR \d+: (\w+)
+//	q₀ -E-> q -R-> ... -' '-> q₆ -\d-> ... -':'-> q -' '-> q -ε-> ( \w+ ) -ε-> accept
+//	└─────── "ERROR " ───────┘  breaks   └─── ": " ───┘    breaks
+
+// Strings shorter than this are not worth a search before the automaton
+const minRequiredLength = 2
+
+type prefilter struct {
+	finder *boyerMoore
+	prefix bool // every match starts with the string
+}
+
+// newPrefilter returns nil when the NFA has no required string of at least minRequiredLength
+func newPrefilter(nfa *NFA) *prefilter {
+	chain := nfa.dominatorChain()
+
+	var best, run []byte
+	var bestFold, runFold bool
+	bestPrefix, runPrefix := false, true
+
+	endRun := func() {
+		if len(run) > len(best) {
+			best, bestFold, bestPrefix = run, runFold, runPrefix
+		}
+		run, runFold, runPrefix = nil, false, false
+	}
+
+	for i := 0; i+1 < len(chain); i++ {
+		state := chain[i]
+		if len(state.Transitions) != 1 || state.IsAccept || state.Transitions[0].Target != chain[i+1] {
+			// Several ways to get to the next state of the chain, nothing is known about what they consume
+			endRun()
+			continue
+		}
+
+		switch matcher := state.Transitions[0].Matcher.(type) {
+		case LiteralMatcher:
+			run = append(run, matcher.Symbol)
+			runFold = runFold || matcher.FoldCase
+
+		default:
+			if !matcher.IsEpsilon() {
+				endRun()
+			}
+		}
+	}
+	endRun()
+
+	if len(best) < minRequiredLength {
+		return nil
+	}
+
+	return &prefilter{
+		finder: newBoyerMoore(string(best), bestFold), // folding finds more occurrences, never fewer
+		prefix: bestPrefix,
+	}
+}
+
+// skip returns where a search for a match at or after pos can start, ok is false when there can't be one
+func (f *prefilter) skip(input []byte, pos int) (start int, ok bool) {
+	offset := f.finder.next(input[pos:])
+	if offset < 0 {
+		return pos, false
+	}
+
+	if f.prefix {
+		return pos + offset, true
+	}
+	return pos, true
+}
+
+// dominatorChain returns the states every path from Start to Accept goes through, from Start to Accept.
+// Dominators are computed with the iterative algorithm of Cooper, Harvey and Kennedy
+// ("A Simple, Fast Dominance Algorithm"): a state's immediate dominator is the nearest common
+// dominator of its predecessors, repeated over the states in reverse postorder until nothing changes.
+func (nfa *NFA) dominatorChain() []*State {
+	states := make([]*State, nfa.NumStates)
+	preds := make([][]int, nfa.NumStates)
+	nfa.walk(func(state *State) {
+		states[state.ID] = state
+		for _, transition := range state.Transitions {
+			preds[transition.Target.ID] = append(preds[transition.Target.ID], state.ID)
+		}
+	})
+
+	// Reverse postorder, iterative so huge NFAs ({1000}) don't overflow the stack
+	order := make([]int, nfa.NumStates) // position in reverse postorder, -1 if unreachable
+	for i := range order {
+		order[i] = -1
+	}
+	var postorder []int
+	visited := make([]bool, nfa.NumStates)
+	type frame struct {
+		id   int
+		next int // index of the next transition to follow
+	}
+	stack := []frame{{id: nfa.Start.ID}}
+	visited[nfa.Start.ID] = true
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		transitions := states[top.id].Transitions
+		if top.next < len(transitions) {
+			target := transitions[top.next].Target.ID
+			top.next++
+			if !visited[target] {
+				visited[target] = true
+				stack = append(stack, frame{id: target})
+			}
+			continue
+		}
+
+		postorder = append(postorder, top.id)
+		stack = stack[:len(stack)-1]
+	}
+	rpo := make([]int, 0, len(postorder))
+	for i := len(postorder) - 1; i >= 0; i-- {
+		order[postorder[i]] = len(rpo)
+		rpo = append(rpo, postorder[i])
+	}
+
+	idom := make([]int, nfa.NumStates)
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[nfa.Start.ID] = nfa.Start.ID
+
+	intersect := func(a int, b int) int {
+		for a != b {
+			for order[a] > order[b] {
+				a = idom[a]
+			}
+			for order[b] > order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, id := range rpo[1:] {
+			newIdom := -1
+			for _, pred := range preds[id] {
+				if idom[pred] < 0 {
+					continue
+				}
+				if newIdom < 0 {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+
+			if newIdom != idom[id] {
+				idom[id] = newIdom
+				changed = true
+			}
+		}
+	}
+
+	if idom[nfa.Accept.ID] < 0 {
+		return nil
+	}
+
+	var chain []*State
+	for id := nfa.Accept.ID; ; id = idom[id] {
+		chain = append(chain, states[id])
+		if id == nfa.Start.ID {
+			break
+		}
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+
+	return chain
+}
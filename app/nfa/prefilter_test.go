@@ -0,0 +1,79 @@
+package nfa
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPrefilterString(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		want       string // "" for no prefilter
+		wantPrefix bool
+	}{
+		{`ERROR \d+: (\w+)`, "error ", true},
+		{`[a-z]+\.log`, ".log", false},
+		{`(foo|bar)baz`, "baz", false},
+		{`foo|bar`, "", false},
+		{`a.b`, "", false},
+		{`ab*c`, "", false},
+		{`x(ab)+y`, "xab", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re, err := CompileFlags(tt.pattern, Longest|FoldCase)
+			if err != nil {
+				t.Fatalf("CompileFlags(%q) error: %v", tt.pattern, err)
+			}
+
+			var got string
+			var prefix bool
+			if re.prefilter != nil {
+				got, prefix = string(re.prefilter.finder.pattern), re.prefilter.prefix
+			}
+			if got != tt.want || prefix != tt.wantPrefix {
+				t.Errorf("prefilter = %q, prefix %v, want %q, prefix %v", got, prefix, tt.want, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+// The prefilter must never reject an input that matches, nor skip past the start of its match
+func TestPrefilterNeverRejectsAMatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	atoms := []string{"a", "b", "c", "ab", "bc", "abc", ".", "[ab]", "(a|bc)", "(ab)*", "c?", "b+", "(abc|ab)", "^", "$", `\b`}
+	pick := func(n int) string {
+		s := ""
+		for range n {
+			s += atoms[rng.Intn(len(atoms))]
+		}
+		return s
+	}
+
+	for range 5000 {
+		pattern := pick(1 + rng.Intn(5))
+		re, err := CompileFlags(pattern, Longest)
+		if err != nil {
+			t.Fatalf("CompileFlags(%q) error: %v", pattern, err)
+		}
+		if re.prefilter == nil || re.pike == nil {
+			continue
+		}
+
+		input := make([]byte, rng.Intn(10))
+		for i := range input {
+			input[i] = "abcx"[rng.Intn(4)]
+		}
+
+		slots := re.pike.search(input, 0)
+		if slots == nil {
+			continue
+		}
+
+		start, ok := re.prefilter.skip(input, 0)
+		if !ok || start > slots[0] {
+			t.Fatalf("%q on %q: match at %d, prefilter skip = %d, %v", pattern, input, slots[0], start, ok)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package nfa
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Regexp is a compiled regular expression.
+// The pattern is parsed and the Thompson NFA is built exactly once in Compile,
+// after that the Regexp is never mutated, so it can be reused for every line of the input.
+type Regexp struct {
+	pattern   string
+	flags     Flags
+	nfa       *NFA
+	dfa       *lazyDFA         // nil when the pattern needs the NFA (backreferences)
+	pike      *pikeVM          // same, used whenever captures or match positions are needed
+	literal   *literalSearcher // replaces both when the pattern is only fixed strings, see literals
+	literals  []string         // the fixed strings of a Literal pattern or of an alternation of literals
+	prefilter *prefilter       // a string every match contains, searched before the automata run, see prefilter.go
+	anchored  bool             // every match starts at position 0, the pattern begins with ^ or \A
+}
+
+// Flags change how a pattern is compiled and which match is reported
+type Flags uint
+
+const (
+	// Longest selects POSIX leftmost-longest semantics: of all matches starting at the leftmost
+	// position the longest one wins, like grep -E.
+	// Without it the semantics are Perl's leftmost-first: the match found by the highest priority
+	// path wins, greedy quantifiers and earlier alternatives are preferred.
+	//
+	//	pattern a|ab on "ab": leftmost-first "a", leftmost-longest "ab"
+	//
+	// Only the overall match is POSIX. The engines track the groups of the highest priority path,
+	// POSIX wants the longest subexpressions instead, so FindSubmatch and the other Submatch methods
+	// report every group but 0 unset. Backreferences still see the groups of their own path.
+	//
+	//	pattern (a|ab)(c|bcd)(d*) on "abcd": match "abcd", POSIX groups "ab" "cd" "", reported unset
+	//
+	// Non-greedy quantifiers (*? +? ?? {m,n}?) are an error with it: the longest match ignores them,
+	// a.*?b would silently match the same as a.*b.
+	Longest Flags = 1 << iota
+
+	// FoldCase matches letters case-insensitively, like starting the pattern with (?i).
+	// Literals, bracket expressions and backreferences all fold, ASCII letters only unless UTF8 is set.
+	FoldCase
+
+	// UTF8 treats the pattern and the input as UTF-8: ., negated classes and ranges match whole runes,
+	// \p{Greek} style classes are allowed and FoldCase uses Unicode case folding.
+	// \w, \s, the POSIX classes, the word assertions and WholeWord follow the locale's classes
+	// (é is a letter, see localeClasses), except with Perl where they stay ASCII like in grep -P.
+	// \d is 0-9 either way.
+	UTF8
+
+	// Perl allows the Perl-only lookaround assertions (?=re), (?!re), (?<=re) and (?<!re),
+	// they are a syntax error without it. It doesn't change the match semantics, see Longest.
+	Perl
+
+	// Literal takes the pattern as a newline-separated list of fixed strings, nothing is special.
+	// With CompilePatterns each pattern is one fixed string.
+	// They are found with Boyer-Moore or Aho-Corasick instead of an automaton, see literal.go.
+	// Without it, a pattern that is only an alternation of literals (error|warn|fatal) is searched the same way.
+	Literal
+
+	// WholeWord only reports matches with no word byte right before or after them, like grep -w
+	WholeWord
+
+	// WholeLine only reports matches spanning the whole input, like grep -x.
+	// It takes precedence over WholeWord.
+	WholeLine
+)
+
+// Compile parses the pattern and builds its NFA, with Perl's leftmost-first semantics
+// and syntax, lookarounds included (the Perl flag)
+//
+//	re, err := nfa.Compile(`(\w+)@(\w+\.\w+)`)
+//	re.MatchString("john@example.com")   // true
+//	re.FindIndex([]byte("to: a@b.io"))   // [4 10]
+func Compile(pattern string) (*Regexp, error) {
+	return CompileFlags(pattern, Perl)
+}
+
+// CompilePOSIX is like Compile but with POSIX leftmost-longest semantics (egrep)
+func CompilePOSIX(pattern string) (*Regexp, error) {
+	return CompileFlags(pattern, Longest)
+}
+
+// CompileFlags is like Compile with explicit flags
+func CompileFlags(pattern string, flags Flags) (*Regexp, error) {
+	if flags&Literal != 0 {
+		return CompilePatterns(strings.Split(pattern, "\n"), flags)
+	}
+	return CompilePatterns([]string{pattern}, flags)
+}
+
+// CompilePatterns compiles several patterns into one Regexp matching any of them, like grep -e a -e b.
+// Unlike joining them with '|', each pattern keeps its own group numbers for its backreferences
+// and its own inline flags, see ParseNFA. Submatches are numbered across all of them.
+//
+//	re, err := nfa.CompilePatterns([]string{`(a)\1`, `(b)\1`}, nfa.Longest)
+//	re.MatchString("bb")   // true, the second \1 is group 2
+func CompilePatterns(patterns []string, flags Flags) (*Regexp, error) {
+	re := &Regexp{
+		pattern: strings.Join(patterns, "\n"),
+		flags:   flags,
+	}
+
+	parser := NewNFAParser(patterns...)
+	parser.foldCase = flags&FoldCase != 0
+	parser.utf8 = flags&UTF8 != 0
+	parser.localeClasses = flags&UTF8 != 0 && flags&Perl == 0
+	parser.perl = flags&Perl != 0
+	parser.literal = flags&Literal != 0
+	parser.wholeWord = flags&WholeWord != 0
+	parser.wholeLine = flags&WholeLine != 0
+	nfa, err := parser.ParseNFA()
+	if err != nil {
+		return nil, err
+	}
+	if nfa.HasLazy && flags&Longest != 0 {
+		return nil, fmt.Errorf("non-greedy quantifiers need leftmost-first semantics (grep -P), the longest match ignores them")
+	}
+	re.nfa = nfa
+	if literals, ok := parser.literals(); ok {
+		re.literals = literals
+		re.literal = newLiteralSearcher(literals, flags)
+	}
+	if re.literal == nil {
+		re.prefilter = newPrefilter(nfa)
+	}
+	re.anchored = nfa.isAnchoredStart()
+	re.dfa = newLazyDFA(nfa, re.anchored)
+	if prog := compileProg(nfa); prog != nil {
+		re.pike = newPikeVM(prog, re.anchored, flags&Longest != 0)
+	}
+
+	return re, nil
+}
+
+// MustCompile is like Compile but panics if the pattern cannot be parsed
+func MustCompile(pattern string) *Regexp {
+	re, err := Compile(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("nfa: Compile(%q): %v", pattern, err))
+	}
+
+	return re
+}
+
+// String returns the source pattern
+func (re *Regexp) String() string {
+	return re.pattern
+}
+
+// Match reports whether the input contains any match of the pattern.
+// No captures are needed to answer that, so the lazy DFA is used whenever the pattern allows it.
+func (re *Regexp) Match(input []byte) bool {
+	if re.literal != nil {
+		slots, _ := re.literal.find(input, 0)
+		return slots != nil
+	}
+	if re.prefilter != nil {
+		if _, ok := re.prefilter.skip(input, 0); !ok {
+			return false
+		}
+	}
+	if re.dfa != nil {
+		if matched, ok := re.dfa.match(input); ok {
+			return matched
+		}
+	}
+
+	return re.doExecute(input, 0) != nil
+}
+
+// MatchString is like Match but for a string input
+func (re *Regexp) MatchString(s string) bool {
+	return re.Match([]byte(s))
+}
+
+// NumSubexp returns the number of capture groups, not counting group 0
+func (re *Regexp) NumSubexp() int {
+	return re.nfa.NumGroups
+}
+
+// SubexpNames returns the names of the capture groups, indexed by group number.
+// Element 0 stands for the whole match and is always "", so are unnamed groups.
+//
+//	MustCompile(`(?P<user>\w+)@(\w+)`).SubexpNames()   // ["" "user" ""]
+func (re *Regexp) SubexpNames() []string {
+	return re.nfa.GroupNames
+}
+
+// SubexpIndex returns the number of the group with the given name, or -1 if there is none
+func (re *Regexp) SubexpIndex(name string) int {
+	if name != "" {
+		for i, groupName := range re.nfa.GroupNames {
+			if groupName == name {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Find returns the leftmost match, nil means there was no match
+//
+//	nfa.MustCompile(`\d+`).Find([]byte("id=42"))   // &{Start:3 End:5 Text:42}
+func (re *Regexp) Find(input []byte) *CaptureGroup {
+	slots := re.doExecute(input, 0)
+	if slots == nil {
+		return nil
+	}
+
+	group := newCaptureGroup(input, slots[0], slots[1])
+	return &group
+}
+
+// FindIndex returns the [start, end) byte offsets of the leftmost match,
+// nil means there was no match
+func (re *Regexp) FindIndex(input []byte) []int {
+	slots := re.doExecute(input, 0)
+	if slots == nil {
+		return nil
+	}
+
+	return slots[0:2]
+}
+
+// FindAll returns up to n successive non-overlapping matches (all of them if n < 0)
+//
+//	nfa.MustCompile(`\d+`).FindAll([]byte("1 22 333"), -1)   // "1", "22", "333"
+func (re *Regexp) FindAll(input []byte, n int) []CaptureGroup {
+	var matches []CaptureGroup
+	re.allMatches(input, n, func(slots []int) {
+		matches = append(matches, newCaptureGroup(input, slots[0], slots[1]))
+	})
+
+	return matches
+}
+
+// FindAllIndex is like FindAll but only returns the [start, end) byte offsets
+func (re *Regexp) FindAllIndex(input []byte, n int) [][]int {
+	var matches [][]int
+	re.allMatches(input, n, func(slots []int) {
+		matches = append(matches, slots[0:2])
+	})
+
+	return matches
+}
+
+// FindLiteralIndex is like FindIndex for a pattern made of fixed strings (see Literal),
+// it also returns the index of the one that matched: the line of a Literal pattern, or the alternative.
+// literal is -1 when there is no match or the pattern is not made of fixed strings.
+//
+//	nfa.MustCompile(`error|warn|fatal`).FindLiteralIndex([]byte("a warning"))   // [2 6] 1
+func (re *Regexp) FindLiteralIndex(input []byte) (loc []int, literal int) {
+	if re.literal != nil {
+		return re.literal.find(input, 0)
+	}
+
+	loc = re.FindIndex(input)
+	if loc == nil || re.literals == nil {
+		return loc, -1
+	}
+
+	// Unicode case folding, the automaton engines found it: the first literal equal to the match
+	text := string(input[loc[0]:loc[1]])
+	for i, l := range re.literals {
+		if l == text || re.flags&FoldCase != 0 && strings.EqualFold(l, text) {
+			return loc, i
+		}
+	}
+
+	return loc, -1
+}
+
+// FindSubmatch returns the leftmost match and its capture groups, index i holds group i.
+// Groups that did not take part in the match have Start = End = -1, nil means there was no match
+//
+//	nfa.MustCompile(`(\w+)@(\w+)?`).FindSubmatch([]byte("to a@"))
+//	// [{3 5 a@} {3 4 a} {-1 -1 }]
+func (re *Regexp) FindSubmatch(input []byte) []CaptureGroup {
+	slots := re.FindSubmatchIndex(input)
+	if slots == nil {
+		return nil
+	}
+
+	return newCaptureGroups(input, slots)
+}
+
+// FindSubmatchIndex returns the offsets of the leftmost match and its capture groups,
+// group i spans [result[2*i], result[2*i+1]), -1 if it did not take part in the match
+func (re *Regexp) FindSubmatchIndex(input []byte) []int {
+	return re.submatches(re.doExecute(input, 0))
+}
+
+// FindAllSubmatch is like FindSubmatch for up to n successive non-overlapping matches
+func (re *Regexp) FindAllSubmatch(input []byte, n int) [][]CaptureGroup {
+	var matches [][]CaptureGroup
+	re.allMatches(input, n, func(slots []int) {
+		matches = append(matches, newCaptureGroups(input, re.submatches(slots)))
+	})
+
+	return matches
+}
+
+// FindAllSubmatchIndex is like FindSubmatchIndex for up to n successive non-overlapping matches
+func (re *Regexp) FindAllSubmatchIndex(input []byte, n int) [][]int {
+	var matches [][]int
+	re.allMatches(input, n, func(slots []int) {
+		matches = append(matches, re.submatches(slots))
+	})
+
+	return matches
+}
+
+// submatches returns the slots the Submatch methods report: under Longest the groups are
+// those of the highest priority path and not the POSIX ones, they are reported unset
+func (re *Regexp) submatches(slots []int) []int {
+	if re.flags&Longest != 0 {
+		for i := 2; i < len(slots); i++ {
+			slots[i] = -1
+		}
+	}
+
+	return slots
+}
+
+// allMatches calls deliver with the slots of up to n successive non-overlapping matches.
+// An empty match right after the previous match is skipped, so a* on "baa" gives
+// "" at 0, "aa" at 1, and not another "" at 3.
+func (re *Regexp) allMatches(input []byte, n int, deliver func(slots []int)) {
+	prevEnd := -1
+
+	for pos, count := 0, 0; pos <= len(input) && (n < 0 || count < n); {
+		slots := re.doExecute(input, pos)
+		if slots == nil {
+			break
+		}
+
+		accept := true
+		if slots[1] == pos {
+			// Empty match, step over one byte (one rune in UTF-8 mode) so the search makes progress
+			if slots[0] == prevEnd {
+				accept = false
+			}
+
+			width := 1
+			if re.flags&UTF8 != 0 {
+				_, width = utf8.DecodeRune(input[pos:])
+			}
+			pos += max(width, 1)
+		} else {
+			pos = slots[1]
+		}
+		prevEnd = slots[1]
+
+		if accept {
+			deliver(slots)
+			count++
+		}
+	}
+}
+
+// doExecute returns the slots of the leftmost match starting at or after pos, nil if there is none.
+// The Pike VM finds it in a single pass over the input, only backreferences need
+// the ExecutionContext simulation, which has to be restarted from each start position.
+func (re *Regexp) doExecute(input []byte, pos int) []int {
+	if re.literal != nil {
+		slots, _ := re.literal.find(input, pos)
+		return slots
+	}
+	if re.prefilter != nil {
+		var ok bool
+		if pos, ok = re.prefilter.skip(input, pos); !ok {
+			return nil
+		}
+	}
+	if re.pike != nil {
+		return re.pike.search(input, pos)
+	}
+
+	// i == len(input) too, the empty match at the end of the input counts
+	for i := pos; i <= len(input); i++ {
+		if re.anchored && i > 0 {
+			break
+		}
+
+		result := re.nfa.Run(input, i, re.flags&Longest != 0)
+		if result.Matched {
+			return result.slots(re.nfa.NumGroups)
+		}
+	}
+
+	return nil
+}
+
+// newCaptureGroup returns the group spanning input[start:end], or Start = End = -1 if it's unset
+func newCaptureGroup(input []byte, start int, end int) CaptureGroup {
+	if start < 0 || end < 0 {
+		return CaptureGroup{Start: -1, End: -1}
+	}
+
+	return CaptureGroup{Start: start, End: end, Text: string(input[start:end])}
+}
+
+func newCaptureGroups(input []byte, slots []int) []CaptureGroup {
+	groups := make([]CaptureGroup, len(slots)/2)
+	for i := range groups {
+		groups[i] = newCaptureGroup(input, slots[2*i], slots[2*i+1])
+	}
+
+	return groups
+}
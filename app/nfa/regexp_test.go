@@ -0,0 +1,195 @@
+package nfa
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestEmptyPattern(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags Flags
+		input string
+		want  []int // FindIndex
+	}{
+		{"ERE", Longest, "abc", []int{0, 0}},
+		{"ERE empty input", Longest, "", []int{0, 0}},
+		{"Perl", Perl, "abc", []int{0, 0}},
+		{"fixed string", Longest | Literal, "abc", []int{0, 0}},
+		{"fixed string empty input", Longest | Literal, "", []int{0, 0}},
+		{"whole line", Longest | WholeLine, "", []int{0, 0}},
+		{"whole line not empty", Longest | WholeLine, "abc", nil},
+		{"whole word", Longest | WholeWord, "ab  cd", []int{3, 3}},
+		{"whole word between words", Longest | WholeWord, "ab cd", nil},
+		{"fixed string whole word", Longest | Literal | WholeWord, "ab  cd", []int{3, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := CompileFlags("", tt.flags)
+			if err != nil {
+				t.Fatalf("CompileFlags(\"\") error: %v", err)
+			}
+
+			if got := re.MatchString(tt.input); got != (tt.want != nil) {
+				t.Errorf("MatchString(%q) = %v, want %v", tt.input, got, tt.want != nil)
+			}
+			if got := re.FindIndex([]byte(tt.input)); !equalInts(got, tt.want) {
+				t.Errorf("FindIndex(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestCompilePatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		flags    Flags
+		input    string
+		want     bool
+	}{
+		{"backreference of the second pattern", []string{`(a)\1`, `(b)\1`}, Longest, "bb", true},
+		{"backreference of the first pattern", []string{`(a)\1`, `(b)\1`}, Longest, "aa", true},
+		{"backreferences don't cross patterns", []string{`(a)\1`, `(b)\1`}, Longest, "ab", false},
+		{"named backreference of its own pattern", []string{`(?<n>a)\k<n>`, `(?<n>b)\k<n>`}, Longest, "bb", true},
+		{"empty pattern first", []string{"", "abc"}, Longest, "x", true},
+		{"empty pattern last", []string{"abc", ""}, Longest, "x", true},
+		{"inline flag ends with its pattern", []string{"(?i)a", "b"}, Perl, "B", false},
+		{"inline flag in its pattern", []string{"(?i)a", "b"}, Perl, "A", true},
+		{"fixed strings", []string{"a|b", "c"}, Longest | Literal, "a|b", true},
+		{"fixed strings no ERE", []string{"a|b", "c"}, Longest | Literal, "b", false},
+		{"no pattern", nil, Longest, "abc", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := CompilePatterns(tt.patterns, tt.flags)
+			if err != nil {
+				t.Fatalf("CompilePatterns(%q) error: %v", tt.patterns, err)
+			}
+
+			if got := re.MatchString(tt.input); got != tt.want {
+				t.Errorf("MatchString(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompilePatternsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		flags    Flags
+	}{
+		{"unbalanced halves", []string{"a(", ")b"}, Longest},
+		{"backreference to another pattern", []string{"(a)", `\1`}, Longest},
+		{"backreference to a missing group", []string{`(a)\2`}, Longest},
+		{"unmatched ')' in Perl mode", []string{"a)b"}, Perl},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CompilePatterns(tt.patterns, tt.flags); err == nil {
+				t.Errorf("CompilePatterns(%q) succeeded, want an error", tt.patterns)
+			}
+		})
+	}
+}
+
+func TestLazyQuantifiers(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    []int // FindIndex, leftmost-first
+	}{
+		{`a.*?b`, "aXbXb", []int{0, 3}},
+		{`a+?`, "aaa", []int{0, 1}},
+		{`a??b`, "ab", []int{0, 2}},
+		{`a{2,3}?`, "aaaa", []int{0, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re, err := CompileFlags(tt.pattern, Perl)
+			if err != nil {
+				t.Fatalf("CompileFlags(%q) error: %v", tt.pattern, err)
+			}
+			if got := re.FindIndex([]byte(tt.input)); !equalInts(got, tt.want) {
+				t.Errorf("FindIndex(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+
+			// The longest match ignores them, they are rejected rather than silently greedy
+			if _, err := CompileFlags(tt.pattern, Longest); err == nil {
+				t.Errorf("CompileFlags(%q, Longest) succeeded, want an error", tt.pattern)
+			}
+		})
+	}
+}
+
+// Leftmost-first submatches have to be Go's, down to how a lazy quantifier inside a loop stops
+// once an iteration matches nothing new
+func TestAgreesWithGoRegexp(t *testing.T) {
+	patterns := []string{
+		`(\w*?x*|\s+)*`,
+		`((a|b)*?)+(a|ab)+?`,
+		`(a*?)*`,
+		`(a*?x*)*`,
+		`(a|b)*?b`,
+		`(a+?|b)*c`,
+		`(a??)+b`,
+		`(a*)+b`,
+		`(a|ab)(c|bcd)(d*)`,
+		`((a)|b)+`,
+		`(a?)+b`,
+		`(ab|a)*?b`,
+		`(a{0,2}?b?)*`,
+		`x(a*?)*?y`,
+		`(\s*|a)+`,
+		`\b\w+?\b`,
+	}
+	inputs := []string{"", "x", "xa", "xaA xa", "baa", "ab", "aab", "abab", "abcd", "xaay", "a  b", "aaab cd"}
+
+	for _, pattern := range patterns {
+		re, err := CompileFlags(pattern, Perl)
+		if err != nil {
+			t.Fatalf("CompileFlags(%q) error: %v", pattern, err)
+		}
+		goRe := regexp.MustCompile(pattern)
+
+		for _, input := range inputs {
+			want := goRe.FindStringSubmatchIndex(input)
+			if got := re.FindSubmatchIndex([]byte(input)); !equalInts(got, want) {
+				t.Errorf("%q on %q: FindSubmatchIndex = %v, Go's regexp %v", pattern, input, got, want)
+			}
+		}
+	}
+}
+
+// Compile and MustCompile take Perl syntax, lookarounds are not an error
+func TestCompileIsPerl(t *testing.T) {
+	re := MustCompile(`(?<=a)b`)
+	if got := re.FindIndex([]byte("bab")); !equalInts(got, []int{2, 3}) {
+		t.Errorf("FindIndex = %v, want [2 3]", got)
+	}
+
+	if _, err := Compile(`a(?!b)`); err != nil {
+		t.Errorf("Compile error: %v", err)
+	}
+	if _, err := CompilePOSIX(`a(?!b)`); err == nil {
+		t.Errorf("CompilePOSIX succeeded, want an error")
+	}
+}
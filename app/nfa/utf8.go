@@ -0,0 +1,443 @@
+package nfa
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// UTF-8 mode
+//
+// The engines only ever look at bytes, so rune classes are compiled down to byte automata:
+// a range of runes becomes a few sequences of byte ranges, one per UTF-8 encoding shape.
+//
+//	[α-ω]        U+03B1-U+03C9   ->  [ce][b1-bf] | [cf][80-89]
+//	[\x{80}-\x{7ff}]             ->  [c2-df][80-bf]
+//
+// The sequences share their prefixes, so the fragment is a small trie:
+//
+//	      ┌--[ce]--> q --[b1-bf]--┐
+//	q₀ ---┤                       ├--> q₁
+//	      └--[cf]--> q --[80-89]--┘
+//
+// Lines are not decoded at all, the DFA and the Pike VM still take one byte per step.
+
+// RuneRange is the inclusive range of runes [Lo, Hi]
+type RuneRange struct {
+	Lo rune
+	Hi rune
+}
+
+// anyRune is every valid scalar value, utf8Sequences skips the surrogates
+var anyRune = []RuneRange{{0, unicode.MaxRune}}
+
+// toRuneRanges widens byte ranges, for the ASCII classes (\d, [:alpha:]...) used in UTF-8 mode
+func toRuneRanges(ranges []ByteRange) []RuneRange {
+	runes := make([]RuneRange, len(ranges))
+	for i, r := range ranges {
+		runes[i] = RuneRange{rune(r.Lo), rune(r.Hi)}
+	}
+
+	return runes
+}
+
+// toByteRanges narrows rune ranges that are known to be below 0x100
+func toByteRanges(ranges []RuneRange) []ByteRange {
+	bytes := make([]ByteRange, len(ranges))
+	for i, r := range ranges {
+		bytes[i] = ByteRange{byte(r.Lo), byte(r.Hi)}
+	}
+
+	return bytes
+}
+
+// normalizeRuneRanges sorts the ranges and merges the ones that overlap or touch
+func normalizeRuneRanges(ranges []RuneRange) []RuneRange {
+	if len(ranges) == 0 {
+		return ranges
+	}
+
+	slices.SortFunc(ranges, func(a, b RuneRange) int { return int(a.Lo - b.Lo) })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Lo <= last.Hi+1 {
+			last.Hi = max(last.Hi, r.Hi)
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// negateRuneRanges returns every rune not in the normalized ranges
+func negateRuneRanges(ranges []RuneRange) []RuneRange {
+	var negated []RuneRange
+	next := rune(0) // first rune not covered yet
+
+	for _, r := range ranges {
+		if r.Lo > next {
+			negated = append(negated, RuneRange{next, r.Lo - 1})
+		}
+		next = r.Hi + 1
+	}
+
+	if next <= unicode.MaxRune {
+		negated = append(negated, RuneRange{next, unicode.MaxRune})
+	}
+
+	return negated
+}
+
+// foldRuneRanges adds every simple case folding of every rune in the ranges (k -> K, U+212A KELVIN SIGN)
+func foldRuneRanges(ranges []RuneRange) []RuneRange {
+	folded := slices.Clone(ranges)
+	foldable := foldableRunes()
+
+	for _, r := range ranges {
+		// Only runes with other cases need a look, \P{Greek} alone is a million runes
+		i, _ := slices.BinarySearch(foldable, r.Lo)
+		for ; i < len(foldable) && foldable[i] <= r.Hi; i++ {
+			c := foldable[i]
+			for f := unicode.SimpleFold(c); f != c; f = unicode.SimpleFold(f) {
+				folded = append(folded, RuneRange{f, f})
+			}
+		}
+	}
+
+	return normalizeRuneRanges(folded)
+}
+
+// foldableRunes lists, in order, the runes that have another case.
+// There are none past the Adlam block (U+1E900-U+1E95F).
+var foldableRunes = sync.OnceValue(func() []rune {
+	var runes []rune
+	for c := rune(0); c < 0x1e960; c++ {
+		if unicode.SimpleFold(c) != c {
+			runes = append(runes, c)
+		}
+	}
+
+	return runes
+})
+
+// localeClasses are the [:name:] classes of a UTF-8 locale, modeled on glibc's C.UTF-8.
+// They replace posixClasses, \w and \s in UTF-8 mode, except with Perl: like grep -P,
+// PCRE keeps them ASCII.
+//
+//	alpha   letters, letter numbers (Ⅻ) and the digits of other scripts (٣), not 0-9
+//	digit   0-9 only, and so is xdigit 0-9 A-F a-f
+//	upper   Lu, Lt and Other_Uppercase, lower Ll, Lt and Other_Lowercase (ǅ is both)
+//	space   the spaces a line can break at: not U+00A0, U+2007 or U+202F
+//	cntrl   Cc and the line and paragraph separators U+2028 U+2029
+//	punct   every other printable rune: marks, symbols, ², the no-break spaces...
+var localeClasses = sync.OnceValue(func() map[string][]RuneRange {
+	union := func(ranges ...[]RuneRange) []RuneRange {
+		return normalizeRuneRanges(slices.Concat(ranges...))
+	}
+	minus := func(ranges []RuneRange, remove []RuneRange) []RuneRange {
+		return intersectRuneRanges(ranges, negateRuneRanges(remove))
+	}
+
+	digit := []RuneRange{{'0', '9'}}
+	blank := []RuneRange{{'\t', '\t'}, {' ', ' '}, {0x1680, 0x1680}, {0x2000, 0x2006}, {0x2008, 0x200a}, {0x205f, 0x205f}, {0x3000, 0x3000}}
+	space := union(blank, []RuneRange{{'\n', '\r'}, {0x2028, 0x2029}})
+	cntrl := union(tableRanges(unicode.Cc), []RuneRange{{0x2028, 0x2029}})
+
+	assigned := union(tableRanges(unicode.L), tableRanges(unicode.M), tableRanges(unicode.N),
+		tableRanges(unicode.P), tableRanges(unicode.S), tableRanges(unicode.Z), tableRanges(unicode.C))
+	graph := minus(assigned, union(space, cntrl))
+	alpha := union(tableRanges(unicode.L), tableRanges(unicode.Nl), minus(tableRanges(unicode.Nd), digit))
+	alnum := union(alpha, digit)
+
+	return map[string][]RuneRange{
+		"alnum":  alnum,
+		"alpha":  alpha,
+		"blank":  blank,
+		"cntrl":  cntrl,
+		"digit":  digit,
+		"graph":  graph,
+		"lower":  union(tableRanges(unicode.Ll), tableRanges(unicode.Lt), tableRanges(unicode.Other_Lowercase)),
+		"print":  union(graph, minus(blank, []RuneRange{{'\t', '\t'}})),
+		"punct":  minus(graph, alnum),
+		"space":  space,
+		"upper":  union(tableRanges(unicode.Lu), tableRanges(unicode.Lt), tableRanges(unicode.Other_Uppercase)),
+		"xdigit": toRuneRanges(posixClasses["xdigit"]),
+	}
+})
+
+// localeWordRunes is \w when it follows the locale: [_[:alnum:]]
+var localeWordRunes = sync.OnceValue(func() []RuneRange {
+	return normalizeRuneRanges(append(slices.Clone(localeClasses()["alnum"]), RuneRange{'_', '_'}))
+})
+
+// isLocaleWordRune reports whether r is in localeWordRunes, the word characters of the word assertions
+func isLocaleWordRune(r rune) bool {
+	_, found := slices.BinarySearchFunc(localeWordRunes(), r, func(rr RuneRange, r rune) int {
+		switch {
+		case rr.Hi < r:
+			return -1
+		case rr.Lo > r:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return found
+}
+
+// localeShorthand returns the ranges of \w and \s, negated is set for \W and \S.
+// ok is false for any other escape, and when the classes don't follow the locale.
+func (p *NFAParser) localeShorthand(symbol byte) (ranges []RuneRange, negated bool, ok bool) {
+	if !p.localeClasses {
+		return nil, false, false
+	}
+
+	switch symbol {
+	case 'w', 'W':
+		ranges = localeWordRunes()
+	case 's', 'S':
+		ranges = localeClasses()["space"]
+	default:
+		return nil, false, false
+	}
+
+	return ranges, symbol < 'a', true
+}
+
+// intersectRuneRanges returns the runes in both normalized ranges
+func intersectRuneRanges(a []RuneRange, b []RuneRange) []RuneRange {
+	var both []RuneRange
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if lo, hi := max(a[i].Lo, b[j].Lo), min(a[i].Hi, b[j].Hi); lo <= hi {
+			both = append(both, RuneRange{lo, hi})
+		}
+		if a[i].Hi < b[j].Hi {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return both
+}
+
+// tableRanges converts a unicode.RangeTable (\p{Greek}) into rune ranges
+func tableRanges(table *unicode.RangeTable) []RuneRange {
+	var ranges []RuneRange
+	add := func(lo rune, hi rune, stride rune) {
+		if stride == 1 {
+			ranges = append(ranges, RuneRange{lo, hi})
+			return
+		}
+		for c := lo; c <= hi; c += stride {
+			ranges = append(ranges, RuneRange{c, c})
+		}
+	}
+
+	for _, r := range table.R16 {
+		add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	for _, r := range table.R32 {
+		add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+
+	return normalizeRuneRanges(ranges)
+}
+
+// utf8Sequences splits [lo, hi] into ranges whose UTF-8 encodings all have the same length
+// and differ only in a suffix of continuation bytes, then calls emit with the byte range
+// of each encoded position. Surrogates have no UTF-8 encoding and are skipped.
+//
+//	U+0000-U+007F  ->  [00-7f]
+//	U+03B1-U+03C9  ->  [ce][b1-bf], [cf][80-89]
+func utf8Sequences(lo rune, hi rune, emit func(seq []ByteRange)) {
+	if lo > hi {
+		return
+	}
+
+	// Surrogates
+	if lo < 0xe000 && hi >= 0xd800 {
+		utf8Sequences(lo, min(hi, 0xd7ff), emit)
+		utf8Sequences(max(lo, 0xe000), hi, emit)
+		return
+	}
+
+	// Encoding length changes after each of these
+	for _, limit := range []rune{0x7f, 0x7ff, 0xffff} {
+		if lo <= limit && hi > limit {
+			utf8Sequences(lo, limit, emit)
+			utf8Sequences(limit+1, hi, emit)
+			return
+		}
+	}
+
+	if hi <= 0x7f {
+		emit([]ByteRange{{byte(lo), byte(hi)}})
+		return
+	}
+
+	// Split until lo and hi only differ in full continuation bytes (6 bits each),
+	// anything else can't be written as one range per byte
+	for i := 1; i < utf8.UTFMax; i++ {
+		mask := rune(1)<<(6*i) - 1
+		if lo&^mask == hi&^mask {
+			continue
+		}
+		if lo&mask != 0 {
+			utf8Sequences(lo, lo|mask, emit)
+			utf8Sequences((lo|mask)+1, hi, emit)
+			return
+		}
+		if hi&mask != mask {
+			utf8Sequences(lo, hi&^mask-1, emit)
+			utf8Sequences(hi&^mask, hi, emit)
+			return
+		}
+	}
+
+	var loBytes, hiBytes [utf8.UTFMax]byte
+	n := utf8.EncodeRune(loBytes[:], lo)
+	utf8.EncodeRune(hiBytes[:], hi)
+
+	seq := make([]ByteRange, n)
+	for i := range seq {
+		seq[i] = ByteRange{loBytes[i], hiBytes[i]}
+	}
+	emit(seq)
+}
+
+// buildRuneClassNFA compiles a set of runes into a byte-level fragment that consumes
+// exactly one encoded rune of the set, see the trie at the top of this file
+func (p *NFAParser) buildRuneClassNFA(name string, ranges []RuneRange, negated bool) *NFA {
+	ranges = normalizeRuneRanges(slices.Clone(ranges))
+	if p.foldCase {
+		// Before negation, so [^a] rejects A too
+		ranges = foldRuneRanges(ranges)
+	}
+	if negated {
+		ranges = negateRuneRanges(ranges)
+	}
+
+	q0 := p.NewState() // Start state
+	q1 := p.NewState() // Accept state
+	q1.IsAccept = true
+
+	type edge struct {
+		from  *State
+		bytes ByteRange
+	}
+	inner := make(map[edge]*State) // shared prefixes
+
+	for _, r := range ranges {
+		utf8Sequences(r.Lo, r.Hi, func(seq []ByteRange) {
+			curr := q0
+			for _, bytes := range seq[:len(seq)-1] {
+				next, ok := inner[edge{curr, bytes}]
+				if !ok {
+					next = p.NewState()
+					curr.AddTransition(next, CharClassMatcher{Name: name, Ranges: []ByteRange{bytes}})
+					inner[edge{curr, bytes}] = next
+				}
+				curr = next
+			}
+
+			curr.AddTransition(q1, CharClassMatcher{Name: name, Ranges: []ByteRange{seq[len(seq)-1]}})
+		})
+	}
+
+	return &NFA{
+		Start:  q0,
+		Accept: q1,
+	}
+}
+
+// buildRuneNFA matches a single literal rune, in UTF-8 mode a non-ASCII literal
+// is one atom so é+ repeats the whole character
+func (p *NFAParser) buildRuneNFA(r rune) *NFA {
+	// Folding is only a byte swap when every case of r is ASCII, k also folds to U+212A KELVIN SIGN
+	asciiFold := true
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f >= utf8.RuneSelf {
+			asciiFold = false
+		}
+	}
+
+	if p.foldCase && !asciiFold {
+		return p.buildRuneClassNFA(string(r), []RuneRange{{r, r}}, false)
+	}
+
+	if r < utf8.RuneSelf {
+		return p.buildLiteralNFA(byte(r))
+	}
+
+	var nfa *NFA
+	for _, b := range []byte(string(r)) {
+		literal := p.buildLiteralNFA(b)
+		if nfa == nil {
+			nfa = literal
+		} else {
+			nfa = nfa.Concatenate(literal)
+		}
+	}
+
+	return nfa
+}
+
+// parseRune reads the rest of a multi-byte rune whose first byte was just consumed
+func (p *NFAParser) parseRune() (rune, error) {
+	r, size := utf8.DecodeRuneInString(p.pattern[p.pos-1:])
+	if r == utf8.RuneError && size <= 1 {
+		return 0, fmt.Errorf("invalid UTF-8 in pattern at byte %d", p.pos-1)
+	}
+
+	p.pos += size - 1
+	return r, nil
+}
+
+// parseUnicodeClass parses the name after \p or \P: \pL, \p{Greek}, \p{^Greek}.
+// Names are general categories (L, Lu, Nd...), scripts (Greek, Han...) or Any.
+func (p *NFAParser) parseUnicodeClass() (ranges []RuneRange, negated bool, err error) {
+	if !p.utf8 {
+		return nil, false, fmt.Errorf("\\p{...} needs UTF-8 mode")
+	}
+	if p.isEOF() {
+		return nil, false, fmt.Errorf("missing Unicode class name after \\p")
+	}
+
+	name := string(p.advance())
+	if name == "{" {
+		end := strings.IndexByte(p.pattern[p.pos:], '}')
+		if end < 0 {
+			return nil, false, fmt.Errorf("missing closing '}' in \\p{")
+		}
+
+		name = p.pattern[p.pos : p.pos+end]
+		p.pos += end + 1
+	}
+
+	if strings.HasPrefix(name, "^") {
+		name = name[1:]
+		negated = true
+	}
+
+	if name == "Any" {
+		return anyRune, negated, nil
+	}
+
+	table, ok := unicode.Categories[name]
+	if !ok {
+		table, ok = unicode.Scripts[name]
+	}
+	if !ok {
+		return nil, false, fmt.Errorf("unknown Unicode class \\p{%s}", name)
+	}
+
+	return tableRanges(table), negated, nil
+}
@@ -0,0 +1,167 @@
+package nfa
+
+import (
+	"slices"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestUTF8Sequences(t *testing.T) {
+	tests := []struct {
+		name string
+		lo   rune
+		hi   rune
+	}{
+		{"one byte", 0, 0x7f},
+		{"across 0x7f", 0x70, 0x90},
+		{"across 0x7ff", 0x7f0, 0x810},
+		{"across 0xffff", 0xfff0, 0x10010},
+		{"around surrogates", 0xd700, 0xe100},
+		{"all of it", 0, utf8.MaxRune},
+		{"single rune", 0x3b1, 0x3b1},
+		{"Greek", 0x3b1, 0x3c9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seqs [][]ByteRange
+			utf8Sequences(tt.lo, tt.hi, func(seq []ByteRange) {
+				seqs = append(seqs, append([]ByteRange(nil), seq...))
+			})
+
+			// Every rune of the range is matched by exactly one sequence, surrogates and the runes
+			// around the range by none
+			check := func(r rune) {
+				var buf [utf8.UTFMax]byte
+				n := utf8.EncodeRune(buf[:], r)
+
+				matches := 0
+				for _, seq := range seqs {
+					if matchesSequence(seq, buf[:n]) {
+						matches++
+					}
+				}
+
+				want := 0
+				if r >= tt.lo && r <= tt.hi && utf8.ValidRune(r) {
+					want = 1
+				}
+				if matches != want {
+					t.Errorf("U+%04X matched by %d sequences, want %d", r, matches, want)
+				}
+			}
+
+			for r := max(tt.lo-0x100, 0); r <= min(tt.hi+0x100, utf8.MaxRune); r++ {
+				if r-tt.lo > 0x200 && tt.hi-r > 0x200 && r&0xfff != 0 {
+					continue // the middle of a big range, sampled
+				}
+				check(r)
+			}
+		})
+	}
+}
+
+func TestUTF8SequencesBoundaries(t *testing.T) {
+	tests := []struct {
+		lo   rune
+		hi   rune
+		want [][]ByteRange
+	}{
+		{0x7f, 0x80, [][]ByteRange{{{0x7f, 0x7f}}, {{0xc2, 0xc2}, {0x80, 0x80}}}},
+		{0x7ff, 0x800, [][]ByteRange{{{0xdf, 0xdf}, {0xbf, 0xbf}}, {{0xe0, 0xe0}, {0xa0, 0xa0}, {0x80, 0x80}}}},
+		{0xffff, 0x10000, [][]ByteRange{
+			{{0xef, 0xef}, {0xbf, 0xbf}, {0xbf, 0xbf}},
+			{{0xf0, 0xf0}, {0x90, 0x90}, {0x80, 0x80}, {0x80, 0x80}},
+		}},
+		{0xd7ff, 0xe000, [][]ByteRange{{{0xed, 0xed}, {0x9f, 0x9f}, {0xbf, 0xbf}}, {{0xee, 0xee}, {0x80, 0x80}, {0x80, 0x80}}}},
+		{0xd800, 0xdfff, nil},
+	}
+
+	for _, tt := range tests {
+		var got [][]ByteRange
+		utf8Sequences(tt.lo, tt.hi, func(seq []ByteRange) {
+			got = append(got, append([]ByteRange(nil), seq...))
+		})
+
+		if len(got) != len(tt.want) {
+			t.Errorf("utf8Sequences(U+%04X, U+%04X) = %v, want %v", tt.lo, tt.hi, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !slices.Equal(got[i], tt.want[i]) {
+				t.Errorf("utf8Sequences(U+%04X, U+%04X) = %v, want %v", tt.lo, tt.hi, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func matchesSequence(seq []ByteRange, encoded []byte) bool {
+	if len(seq) != len(encoded) {
+		return false
+	}
+	for i, r := range seq {
+		if encoded[i] < r.Lo || encoded[i] > r.Hi {
+			return false
+		}
+	}
+
+	return true
+}
+
+// In UTF-8 mode \w, \W, the POSIX classes and the word assertions follow the locale like GNU grep
+// in C.UTF-8, é is a letter. With Perl they stay ASCII, like grep -P.
+func TestLocaleClasses(t *testing.T) {
+	tests := []struct {
+		pattern string
+		flags   Flags
+		input   string
+		want    []int // FindIndex
+	}{
+		{`caf`, Longest | UTF8 | WholeWord, "café", nil},
+		{`caf`, Longest | UTF8 | WholeWord | Literal, "café", nil},
+		{`café`, Longest | UTF8 | WholeWord | Literal, "le café noir", []int{3, 8}},
+		{`caf`, Perl | UTF8 | WholeWord, "café", []int{0, 3}},
+		{`caf`, Longest | WholeWord, "café", []int{0, 3}},
+
+		{`\W`, Longest | UTF8, "é", nil},
+		{`\W`, Longest | UTF8, "é!", []int{2, 3}},
+		{`\W`, Perl | UTF8, "é", []int{0, 2}},
+		{`\w+`, Longest | UTF8, "café x", []int{0, 5}},
+		{`[^\W]+`, Longest | UTF8, "café!", []int{0, 5}},
+		{`\s`, Longest | UTF8, "é\u2003", []int{2, 5}},
+		{`\s`, Longest | UTF8, "é\u00a0", nil},
+
+		{`[[:alpha:]]+`, Longest | UTF8, "café x", []int{0, 5}},
+		{`[[:alpha:]]+`, Perl | UTF8, "café x", []int{0, 3}},
+		{`[^[:alpha:]]`, Longest | UTF8, "aé", nil},
+		{`[[:digit:]]`, Longest | UTF8, "٣", nil},
+		{`[[:alnum:]]`, Longest | UTF8, "٣", []int{0, 2}},
+		{`[[:upper:]][[:lower:]]`, Longest | UTF8, "ǅǅ", []int{0, 4}},
+		{`[[:punct:]]`, Longest | UTF8, "x·y", []int{1, 3}},
+		{`[[:upper:]]`, Longest | UTF8 | FoldCase, "aé", []int{0, 1}},
+
+		{`caf\b`, Longest | UTF8, "café", nil},
+		{`caf\>`, Longest | UTF8, "café", nil},
+		{`\<a`, Longest | UTF8, "éa", nil},
+		{`\Ba`, Longest | UTF8, "éa", []int{2, 3}},
+		{`caf\b`, Perl | UTF8, "café", []int{0, 3}},
+		{`\bé`, Longest | UTF8, "x é", []int{2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" on "+tt.input, func(t *testing.T) {
+			re, err := CompileFlags(tt.pattern, tt.flags)
+			if err != nil {
+				t.Fatalf("CompileFlags(%q) error: %v", tt.pattern, err)
+			}
+
+			if got := re.FindIndex([]byte(tt.input)); !slices.Equal(got, tt.want) {
+				t.Errorf("flags %d: FindIndex = %v, want %v", tt.flags, got, tt.want)
+			}
+			if got := re.MatchString(tt.input); got != (tt.want != nil) {
+				t.Errorf("flags %d: MatchString = %v, want %v", tt.flags, got, tt.want != nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const progName = "mygrep"
+
+// options is the parsed command line
+type options struct {
+	patterns     []string // from -e, or the first operand
+	files        []string
+	matcher      byte // 'E' (the default), 'F' or 'P', the last of -E, -F and -P wins
+	ignoreCase   bool
+	wordRegexp   bool // -w
+	lineRegexp   bool // -x
+	invert       bool // -v
+	recursive    bool
+	onlyMatching bool
+	lineNumber   bool   // -n
+	byteOffset   bool   // -b
+	column       bool   // --column
+	vimgrep      bool   // --vimgrep
+	count        bool   // -c
+	listFiles    byte   // 'l' or 'L', the last of -l and -L wins, 0 for neither
+	quiet        bool   // -q
+	color        string // --color: "always", "never" (the default) or "auto"
+	help         bool
+
+	// Context lines, -1 when not given. After parsing -A and -B default to -C, whatever the order.
+	afterContext     int // -A
+	beforeContext    int // -B
+	context          int // -C or -NUM
+	groupSeparator   string
+	noGroupSeparator bool
+}
+
+// optionSpec describes one flag, short is 0 for long-only flags and long is "" for short-only ones
+type optionSpec struct {
+	short       byte
+	long        string
+	hasArg      bool
+	optionalArg bool   // long only, the argument can only come after = and is "" without it
+	alias       string // the long option this one is another spelling of, "color" for "colour"
+	exact       bool   // not in GNU grep and only matched by its full name, GNU's abbreviations keep their meaning
+	set         func(o *options, arg string)
+	parse       func(o *options, arg string) error // instead of set when the argument can be invalid
+}
+
+var optionSpecs = []optionSpec{
+	// There is no BRE parser, patterns are extended regular expressions unless -F or -P is given
+	{short: 'E', long: "extended-regexp", set: func(o *options, _ string) { o.matcher = 'E' }},
+	{short: 'F', long: "fixed-strings", set: func(o *options, _ string) { o.matcher = 'F' }},
+	{short: 'P', long: "perl-regexp", set: func(o *options, _ string) { o.matcher = 'P' }},
+	{short: 'e', long: "regexp", hasArg: true, set: func(o *options, arg string) { o.patterns = append(o.patterns, arg) }},
+	{short: 'i', long: "ignore-case", set: func(o *options, _ string) { o.ignoreCase = true }},
+	{short: 'y', set: func(o *options, _ string) { o.ignoreCase = true }}, // obsolete synonym for -i
+	{long: "no-ignore-case", set: func(o *options, _ string) { o.ignoreCase = false }},
+	{short: 'w', long: "word-regexp", set: func(o *options, _ string) { o.wordRegexp = true }},
+	{short: 'x', long: "line-regexp", set: func(o *options, _ string) { o.lineRegexp = true }},
+	{short: 'v', long: "invert-match", set: func(o *options, _ string) { o.invert = true }},
+	{short: 'r', long: "recursive", set: func(o *options, _ string) { o.recursive = true }},
+	{short: 'o', long: "only-matching", set: func(o *options, _ string) { o.onlyMatching = true }},
+	{short: 'n', long: "line-number", set: func(o *options, _ string) { o.lineNumber = true }},
+	{short: 'b', long: "byte-offset", set: func(o *options, _ string) { o.byteOffset = true }},
+	{long: "column", exact: true, set: func(o *options, _ string) { o.column = true }}, // --col is --color
+	{long: "vimgrep", set: func(o *options, _ string) { o.vimgrep = true }},
+	{short: 'c', long: "count", set: func(o *options, _ string) { o.count = true }},
+	{short: 'l', long: "files-with-matches", set: func(o *options, _ string) { o.listFiles = 'l' }},
+	{short: 'L', long: "files-without-match", set: func(o *options, _ string) { o.listFiles = 'L' }},
+	{short: 'q', long: "quiet", set: func(o *options, _ string) { o.quiet = true }},
+	{long: "silent", set: func(o *options, _ string) { o.quiet = true }},
+	{short: 'A', long: "after-context", hasArg: true, parse: func(o *options, arg string) (err error) {
+		o.afterContext, err = contextLength(arg)
+		return err
+	}},
+	{short: 'B', long: "before-context", hasArg: true, parse: func(o *options, arg string) (err error) {
+		o.beforeContext, err = contextLength(arg)
+		return err
+	}},
+	{short: 'C', long: "context", hasArg: true, parse: func(o *options, arg string) (err error) {
+		o.context, err = contextLength(arg)
+		return err
+	}},
+	{long: "group-separator", hasArg: true, set: func(o *options, arg string) {
+		o.groupSeparator, o.noGroupSeparator = arg, false
+	}},
+	{long: "no-group-separator", set: func(o *options, _ string) { o.noGroupSeparator = true }},
+	{long: "color", optionalArg: true, parse: parseColor},
+	{long: "colour", alias: "color", optionalArg: true, parse: parseColor},
+	{long: "help", set: func(o *options, _ string) { o.help = true }},
+}
+
+// apply runs the flag's set or parse function
+func (spec *optionSpec) apply(o *options, arg string) error {
+	if spec.parse != nil {
+		return spec.parse(o, arg)
+	}
+
+	spec.set(o, arg)
+	return nil
+}
+
+// parseColor parses WHEN of --color[=WHEN], with GNU grep's synonyms. --color alone is auto.
+func parseColor(o *options, arg string) error {
+	switch arg {
+	case "always", "yes", "force":
+		o.color = "always"
+	case "never", "no", "none":
+		o.color = "never"
+	case "auto", "tty", "if-tty", "":
+		o.color = "auto"
+	default:
+		return &usageError{fmt.Sprintf("invalid argument '%s' for '--color'", arg)}
+	}
+
+	return nil
+}
+
+// contextLength parses the NUM of -A, -B, -C and -NUM, a huge one is as good as unlimited
+func contextLength(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	switch {
+	case errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(arg, "-"):
+		return math.MaxInt, nil
+	case err != nil || n < 0:
+		return 0, fmt.Errorf("%s: invalid context length argument", arg)
+	}
+
+	return n, nil
+}
+
+// usageError is a bad command line, main reports it the way GNU grep does and exits with 2.
+// An empty msg only prints the usage lines.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// parseArgs parses the arguments after the program name, getopt_long style:
+//
+//	-rnE          short flags can be combined
+//	-epat -e pat  a short flag's argument is the rest of the word or the next word
+//	--regexp=pat  long flags take their argument after = or as the next word
+//	--rec         long flags can be abbreviated as long as the prefix is unambiguous
+//	pat -r dir    flags and operands can come in any order
+//	-- -pat       everything after -- is an operand
+//	-3            digits are a number, -NUM is --context=NUM
+//
+// Without -e the first operand is the pattern, the rest are files.
+func parseArgs(args []string) (*options, error) {
+	o := &options{
+		color:          "never",
+		afterContext:   -1,
+		beforeContext:  -1,
+		context:        -1,
+		groupSeparator: "--",
+	}
+	var operands []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "--":
+			operands = append(operands, args[i+1:]...)
+			i = len(args)
+
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			spec, err := lookupLong(name)
+			if err != nil {
+				return nil, err
+			}
+
+			if !spec.hasArg {
+				if hasValue && !spec.optionalArg {
+					return nil, &usageError{fmt.Sprintf("option '--%s' doesn't allow an argument", spec.long)}
+				}
+				if err := spec.apply(o, value); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			if !hasValue {
+				if i+1 == len(args) {
+					return nil, &usageError{fmt.Sprintf("option '--%s' requires an argument", spec.long)}
+				}
+				i++
+				value = args[i]
+			}
+			if err := spec.apply(o, value); err != nil {
+				return nil, err
+			}
+
+		case len(arg) > 1 && arg[0] == '-':
+			// A cluster of short flags, a flag with an argument takes the rest of the cluster
+			for j := 1; j < len(arg); j++ {
+				if isDigit(arg[j]) {
+					end := j + 1
+					for end < len(arg) && isDigit(arg[end]) {
+						end++
+					}
+					n, err := contextLength(arg[j:end])
+					if err != nil {
+						return nil, err
+					}
+					o.context = n
+					j = end - 1
+					continue
+				}
+
+				spec := lookupShort(arg[j])
+				if spec == nil {
+					return nil, &usageError{fmt.Sprintf("invalid option -- '%c'", arg[j])}
+				}
+
+				if !spec.hasArg {
+					spec.set(o, "")
+					continue
+				}
+
+				value := arg[j+1:]
+				if value == "" {
+					if i+1 == len(args) {
+						return nil, &usageError{fmt.Sprintf("option requires an argument -- '%c'", arg[j])}
+					}
+					i++
+					value = args[i]
+				}
+				if err := spec.apply(o, value); err != nil {
+					return nil, err
+				}
+				break
+			}
+
+		default:
+			// "-" alone is an operand too (stdin)
+			operands = append(operands, arg)
+		}
+	}
+
+	if o.help {
+		return o, nil
+	}
+
+	if o.afterContext < 0 {
+		o.afterContext = o.context
+	}
+	if o.beforeContext < 0 {
+		o.beforeContext = o.context
+	}
+
+	if len(o.patterns) == 0 {
+		if len(operands) == 0 {
+			return nil, &usageError{}
+		}
+		o.patterns = operands[:1]
+		operands = operands[1:]
+	}
+	o.files = operands
+
+	return o, nil
+}
+
+// canonical is the long name of the option, the same for all its spellings
+func (spec *optionSpec) canonical() string {
+	if spec.alias != "" {
+		return spec.alias
+	}
+
+	return spec.long
+}
+
+func lookupShort(c byte) *optionSpec {
+	for i := range optionSpecs {
+		if optionSpecs[i].short == c {
+			return &optionSpecs[i]
+		}
+	}
+
+	return nil
+}
+
+// lookupLong finds the long flag by its full name or an unambiguous prefix.
+// Like getopt_long, a prefix of two spellings of the same option (--col) is not ambiguous.
+func lookupLong(name string) (*optionSpec, error) {
+	var found *optionSpec
+	ambiguous := false
+
+	for i := range optionSpecs {
+		spec := &optionSpecs[i]
+		if spec.long == "" || !strings.HasPrefix(spec.long, name) {
+			continue
+		}
+
+		if spec.long == name {
+			return spec, nil
+		}
+		if spec.exact {
+			continue
+		}
+		if found != nil && found.canonical() != spec.canonical() {
+			ambiguous = true
+		}
+		if found == nil {
+			found = spec
+		}
+	}
+
+	if found == nil || name == "" {
+		return nil, &usageError{fmt.Sprintf("unrecognized option '--%s'", name)}
+	}
+	if ambiguous {
+		return nil, &usageError{fmt.Sprintf("option '--%s' is ambiguous", name)}
+	}
+
+	return found, nil
+}
+
+// patternLines returns every line of every -e pattern, a line matching any of them is selected.
+// They are compiled as separate patterns, see nfa.CompilePatterns.
+func (o *options) patternLines() []string {
+	var lines []string
+	for _, p := range o.patterns {
+		lines = append(lines, strings.Split(p, "\n")...)
+	}
+
+	return lines
+}
+
+// withFileName reports whether output lines are prefixed with the file name: like GNU grep,
+// when there is more than one file operand or the only one is a directory searched recursively.
+// grep -r without operands searches ".", grep -r pat file.txt has no prefix.
+func (o *options) withFileName() bool {
+	switch {
+	case o.vimgrep, len(o.files) > 1:
+		return true
+	case !o.recursive:
+		return false
+	}
+
+	return len(o.files) == 0 || isDir(o.files[0])
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, `Usage: %s [OPTION]... PATTERNS [FILE]...
+Search for PATTERNS in each FILE.
+Example: %s -E 'hello|world' menu.h main.c
+
+Pattern selection and interpretation:
+  -E, --extended-regexp     PATTERNS are extended regular expressions
+  -F, --fixed-strings       PATTERNS are strings
+  -P, --perl-regexp         PATTERNS are Perl regular expressions
+  -e, --regexp=PATTERNS     use PATTERNS for matching
+  -i, --ignore-case         ignore case distinctions in patterns and data
+      --no-ignore-case      do not ignore case distinctions (default)
+  -w, --word-regexp         match only whole words
+  -x, --line-regexp         match only whole lines
+
+Miscellaneous:
+  -v, --invert-match        select non-matching lines
+
+Output control:
+  -o, --only-matching       show only nonempty parts of lines that match
+  -q, --quiet, --silent     suppress all normal output
+  -r, --recursive           search directories recursively
+  -L, --files-without-match  print only names of FILEs with no selected lines
+  -l, --files-with-matches  print only names of FILEs with selected lines
+  -c, --count               print only a count of selected lines per FILE
+
+Output line prefix control:
+  -b, --byte-offset         print the byte offset with output lines
+  -n, --line-number         print line number with output lines
+      --column              print the column of the first match, implies -n
+      --vimgrep             print FILE:LINE:COLUMN:line once for every match
+
+Context control:
+  -B, --before-context=NUM  print NUM lines of leading context
+  -A, --after-context=NUM   print NUM lines of trailing context
+  -C, --context=NUM         print NUM lines of output context
+  -NUM                      same as --context=NUM
+      --group-separator=SEP  print SEP on line between matches with context
+      --no-group-separator  do not print separator for matches with context
+      --color[=WHEN],
+      --colour[=WHEN]       use markers to highlight the matching strings;
+                            WHEN is 'always', 'never', or 'auto'
+
+With no FILE, read standard input, or the working directory with -r.
+Exit status is 0 if any line is selected, 1 otherwise;
+if any error occurs, the exit status is 2.
+Colors are taken from GREP_COLORS like GNU grep, g1 to g9 color capture groups.
+`, progName, progName)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		check func(o *options) bool
+	}{
+		{"bundled short options", []string{"-inr", "pat", "dir"}, func(o *options) bool {
+			return o.ignoreCase && o.lineNumber && o.recursive && slices.Equal(o.files, []string{"dir"})
+		}},
+		{"bundled option taking the rest as argument", []string{"-ve", "pat"}, func(o *options) bool {
+			return o.invert && slices.Equal(o.patterns, []string{"pat"}) && o.files == nil
+		}},
+		{"argument glued to the short option", []string{"-A3", "-epat"}, func(o *options) bool {
+			return o.afterContext == 3 && o.beforeContext == -1 && slices.Equal(o.patterns, []string{"pat"})
+		}},
+		{"long option with =", []string{"--after-context=2", "--regexp=a=b"}, func(o *options) bool {
+			return o.afterContext == 2 && slices.Equal(o.patterns, []string{"a=b"})
+		}},
+		{"long option with separate argument", []string{"--regexp", "pat", "file"}, func(o *options) bool {
+			return slices.Equal(o.patterns, []string{"pat"}) && slices.Equal(o.files, []string{"file"})
+		}},
+		{"unambiguous abbreviation", []string{"--inv", "--ignore", "pat"}, func(o *options) bool {
+			return o.invert && o.ignoreCase
+		}},
+		{"exact name that is also a prefix", []string{"--context=1", "pat"}, func(o *options) bool {
+			return o.afterContext == 1 && o.beforeContext == 1
+		}},
+		{"-NUM is the context", []string{"-2n", "pat"}, func(o *options) bool {
+			return o.context == 2 && o.lineNumber
+		}},
+		{"-C is the default of -A and -B in any order", []string{"-A1", "-C3", "pat"}, func(o *options) bool {
+			return o.afterContext == 1 && o.beforeContext == 3
+		}},
+		{"options after operands", []string{"pat", "file", "-c"}, func(o *options) bool {
+			return o.count && slices.Equal(o.files, []string{"file"})
+		}},
+		{"-- ends the options", []string{"--", "-pat", "-file"}, func(o *options) bool {
+			return slices.Equal(o.patterns, []string{"-pat"}) && slices.Equal(o.files, []string{"-file"})
+		}},
+		{"optional argument", []string{"--color", "pat"}, func(o *options) bool {
+			return o.color == "auto"
+		}},
+		{"abbreviation of two spellings", []string{"--col", "pat"}, func(o *options) bool {
+			return o.color == "auto" && !o.column
+		}},
+		{"abbreviation with an argument", []string{"--col=never", "pat"}, func(o *options) bool {
+			return o.color == "never"
+		}},
+		{"-y is -i", []string{"-y", "pat"}, func(o *options) bool {
+			return o.ignoreCase
+		}},
+		{"--column by its full name", []string{"--column", "pat"}, func(o *options) bool {
+			return o.column && o.color == "never"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := parseArgs(tt.args)
+			if err != nil {
+				t.Fatalf("parseArgs(%q) error: %v", tt.args, err)
+			}
+			if !tt.check(o) {
+				t.Errorf("parseArgs(%q) = %+v", tt.args, *o)
+			}
+		})
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"ambiguous abbreviation", []string{"--line", "pat"}, "option '--line' is ambiguous"},
+		{"ambiguous no-", []string{"--no", "pat"}, "option '--no' is ambiguous"},
+		{"unknown long option", []string{"--nope", "pat"}, "unrecognized option '--nope'"},
+		{"ambiguous with two spellings", []string{"--co", "pat"}, "option '--co' is ambiguous"},
+		{"--column is not abbreviated", []string{"--colu", "pat"}, "unrecognized option '--colu'"},
+		{"unknown short option in a bundle", []string{"-iZ", "pat"}, "invalid option -- 'Z'"},
+		{"argument not allowed", []string{"--count=3", "pat"}, "option '--count' doesn't allow an argument"},
+		{"missing argument", []string{"pat", "-e"}, "option requires an argument -- 'e'"},
+		{"missing long argument", []string{"pat", "--regexp"}, "option '--regexp' requires an argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseArgs(tt.args)
+			if err == nil {
+				t.Fatalf("parseArgs(%q) succeeded, want %q", tt.args, tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("parseArgs(%q) error = %q, want %q", tt.args, err.Error(), tt.want)
+			}
+		})
+	}
+}